@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Response Sent to Client for Blocked or Filtered Name
+type BlockPolicy struct {
+	mode string
+	ipv4 net.IP
+	ipv6 net.IP
+	ttl  uint32
+	ede  uint16
+}
+
+func NewBlockPolicy(cfg BlockResponseConfig) (*BlockPolicy, error) {
+	p := &BlockPolicy{
+		mode: strings.ToLower(cfg.Mode),
+		ttl:  uint32(max(cfg.TTL, 0)),
+	}
+
+	switch p.mode {
+	case "", "nxdomain":
+		p.mode = "nxdomain"
+	case "nodata", "refused":
+	case "null":
+		p.ipv4 = net.IPv4zero
+		p.ipv6 = net.IPv6zero
+	case "sinkhole":
+		if cfg.IPv4 == "" && cfg.IPv6 == "" {
+			return nil, fmt.Errorf("Error Sinkhole Response Requires IPv4 or IPv6 Address")
+		}
+	default:
+		return nil, fmt.Errorf("Error Invalid Block Response Mode '%s'", cfg.Mode)
+	}
+
+	if cfg.IPv4 != "" && p.mode == "sinkhole" {
+		if p.ipv4 = net.ParseIP(cfg.IPv4).To4(); p.ipv4 == nil {
+			return nil, fmt.Errorf("Error Invalid Sinkhole IPv4 Address '%s'", cfg.IPv4)
+		}
+	}
+
+	if cfg.IPv6 != "" && p.mode == "sinkhole" {
+		if p.ipv6 = net.ParseIP(cfg.IPv6); p.ipv6 == nil || p.ipv6.To4() != nil {
+			return nil, fmt.Errorf("Error Invalid Sinkhole IPv6 Address '%s'", cfg.IPv6)
+		}
+	}
+
+	switch strings.ToLower(cfg.ExtendedError) {
+	case "", "blocked":
+		p.ede = dns.ExtendedErrorCodeBlocked
+	case "filtered":
+		p.ede = dns.ExtendedErrorCodeFiltered
+	case "none":
+		p.ede = 0
+	default:
+		return nil, fmt.Errorf("Error Invalid Extended DNS Error '%s'", cfg.ExtendedError)
+	}
+
+	return p, nil
+}
+
+func (p *BlockPolicy) String() string {
+	return p.mode
+}
+
+// Derive Policy from Adblock $dnsrewrite Value, Which is Either
+// a Response Code (NXDOMAIN, REFUSED, NOERROR) or a Sinkhole IP Address
+func (p *BlockPolicy) rewrite(value string) (*BlockPolicy, error) {
+	rp := *p
+	rp.ipv4, rp.ipv6 = nil, nil
+
+	switch strings.ToUpper(value) {
+	case "NXDOMAIN":
+		rp.mode = "nxdomain"
+	case "REFUSED":
+		rp.mode = "refused"
+	case "NOERROR":
+		rp.mode = "nodata"
+	default:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("Error Invalid DNS Rewrite '%s'", value)
+		}
+
+		rp.mode = "sinkhole"
+		if ip4 := ip.To4(); ip4 != nil {
+			rp.ipv4 = ip4
+		} else {
+			rp.ipv6 = ip
+		}
+	}
+
+	return &rp, nil
+}
+
+// Build Response to Request r According to Policy
+func (p *BlockPolicy) Response(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	q := r.Question[0]
+
+	switch p.mode {
+	case "nxdomain":
+		m.Rcode = dns.RcodeNameError
+	case "refused":
+		m.Rcode = dns.RcodeRefused
+	case "null", "sinkhole":
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: p.ttl}
+
+		if q.Qtype == dns.TypeA && p.ipv4 != nil {
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: p.ipv4})
+		} else if q.Qtype == dns.TypeAAAA && p.ipv6 != nil {
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: p.ipv6})
+		}
+	}
+
+	// SOA Carries TTL of Negative Answers (RFC 2308)
+	if (m.Rcode == dns.RcodeNameError || m.Rcode == dns.RcodeSuccess) && len(m.Answer) == 0 {
+		m.Ns = append(m.Ns, &dns.SOA{
+			Hdr:     dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: p.ttl},
+			Ns:      "blocked.",
+			Mbox:    "blocked.",
+			Serial:  1,
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  86400,
+			Minttl:  p.ttl,
+		})
+	}
+
+	// Extended DNS Error Only Goes to Clients Speaking EDNS (RFC 8914)
+	if opt := r.IsEdns0(); opt != nil && p.ede != 0 {
+		respOpt := new(dns.OPT)
+		respOpt.Hdr.Name = "."
+		respOpt.Hdr.Rrtype = dns.TypeOPT
+		respOpt.SetUDPSize(max(opt.UDPSize(), dns.MinMsgSize))
+		respOpt.Option = append(respOpt.Option, &dns.EDNS0_EDE{InfoCode: p.ede})
+
+		m.Extra = append(m.Extra, respOpt)
+	}
+
+	return m
+}
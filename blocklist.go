@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	blockExact = 1 << iota
+	blockSubdomains
+	allowExact
+	allowSubdomains
+	blockImportant
+)
+
+// Suffix Trie Node Keyed by Domain Label, Starting from TLD
+type blockNode struct {
+	children map[string]*blockNode
+	flags    uint8
+	policy   uint16
+}
+
+// Glob or Regex Rule, Flags are Same as Trie Node Flags
+type blockPattern struct {
+	*domainPattern
+	flags  uint8
+	policy uint16
+}
+
+type blockMatcher struct {
+	root       *blockNode
+	globs      []blockPattern
+	regexps    []blockPattern
+	policies   []*BlockPolicy
+	rewrites   map[string]uint16
+	rules      int
+	exceptions int
+}
+
+type Blocklist struct {
+	files   []string
+	rules   []string
+	sources []*blocklistSource
+	policy  *BlockPolicy
+	client  *http.Client
+	matcher atomic.Pointer[blockMatcher]
+	stop    chan struct{}
+}
+
+func NewBlocklist(cfg BlocklistConfig, upstreamCfg UpstreamConfig) (*Blocklist, error) {
+	policy, err := NewBlockPolicy(cfg.Response)
+	if err != nil {
+		return nil, err
+	}
+
+	bl := &Blocklist{
+		policy: policy,
+		stop:   make(chan struct{}),
+	}
+
+	if cfg.Enable {
+		// Subscriptions are Downloaded with DoH Client Transport Settings,
+		// But Always Verify TLS and Allow Slow Downloads of Large Lists
+		bl.client = newDoHClient(upstreamCfg, "", false, "", time.Duration(upstreamCfg.Timeout)*time.Second)
+		bl.client.Timeout = blocklistDownloadTimeout
+
+		bl.files = cfg.Files
+		bl.rules = cfg.Rules
+
+		for _, sub := range cfg.Subscriptions {
+			src, err := newBlocklistSource(sub, cfg)
+			if err != nil {
+				log.Printf("Error Blocklist Subscription '%s': %v", sub.URL, err)
+				continue
+			}
+
+			bl.sources = append(bl.sources, src)
+		}
+	}
+
+	// Last Good Copies of Subscriptions are Used Until Refreshed
+	bl.compile()
+
+	return bl, nil
+}
+
+// Build New Matcher from All Sources and Swap it in Atomically
+func (bl *Blocklist) compile() {
+	// Policy Index 0 is The Default Response
+	m := &blockMatcher{
+		root:     new(blockNode),
+		policies: []*BlockPolicy{bl.policy},
+		rewrites: make(map[string]uint16),
+	}
+
+	for _, path := range bl.files {
+		if err := m.loadFile(path, 0); err != nil {
+			log.Printf("Error Failed to Load Blocklist %s: %v", path, err)
+		}
+	}
+
+	for _, src := range bl.sources {
+		policy := uint16(0)
+		if src.policy != nil {
+			policy = m.addPolicy(src.policy)
+		}
+
+		if err := m.loadFile(src.path, policy); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Error Failed to Load Blocklist %s: %v", src, err)
+		}
+	}
+
+	for _, rule := range bl.rules {
+		m.addRule(rule, 0)
+	}
+
+	bl.matcher.Store(m)
+}
+
+func (m *blockMatcher) addPolicy(p *BlockPolicy) uint16 {
+	m.policies = append(m.policies, p)
+	return uint16(len(m.policies) - 1)
+}
+
+func (m *blockMatcher) loadFile(path string, policy uint16) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return m.load(file, policy)
+}
+
+func (m *blockMatcher) load(r io.Reader, policy uint16) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m.addRule(scanner.Text(), policy)
+	}
+
+	return scanner.Err()
+}
+
+// Parse One Line in Hosts, Domain List or Adblock Format:
+//
+//	0.0.0.0 ads.example.com    Block Exact Domain (Hosts)
+//	ads.example.com            Block Exact Domain (Domain List)
+//	*.ads.example.com          Block Subdomains Only
+//	||ads.example.com^         Block Domain and Its Subdomains
+//	@@||ads.example.com^       Exception for Domain and Its Subdomains
+//	||ad*.example.com^         Glob Matching Domain and Its Subdomains
+//	/^ad[0-9]+\./              Regex Matching Full Name
+//
+// Adblock Rules May Have $important and $dnsrewrite Modifiers
+func (m *blockMatcher) addRule(line string, policy uint16) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+		return
+	}
+
+	// Strip Trailing Comment
+	if i := strings.Index(line, " #"); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+
+	fields := strings.Fields(line)
+
+	// Hosts Format, Every Name After IP Address is Blocked
+	if len(fields) > 1 {
+		if net.ParseIP(fields[0]) == nil {
+			return
+		}
+
+		for _, domain := range fields[1:] {
+			switch domain {
+			case "localhost", "localhost.localdomain", "local", "broadcasthost", "ip6-localhost", "ip6-loopback", "0.0.0.0":
+				continue
+			}
+
+			m.insert(domain, blockExact, policy)
+		}
+
+		return
+	}
+
+	rule := fields[0]
+
+	exception := false
+	if strings.HasPrefix(rule, "@@") {
+		exception = true
+		rule = rule[2:]
+	}
+
+	// Modifiers of Regex Rule Start After Closing Slash
+	i := strings.IndexByte(rule, '$')
+	if strings.HasPrefix(rule, "/") {
+		if j := strings.LastIndexByte(rule, '/'); j > 0 {
+			i = strings.IndexByte(rule[j:], '$')
+			if i >= 0 {
+				i += j
+			}
+		}
+	}
+
+	important := false
+	if i >= 0 {
+		for _, modifier := range strings.Split(rule[i+1:], ",") {
+			name, value, _ := strings.Cut(modifier, "=")
+
+			switch name {
+			case "important":
+				important = true
+
+			case "dnsrewrite":
+				rewrite, err := m.rewritePolicy(policy, value)
+				if err != nil {
+					return
+				}
+
+				policy = rewrite
+
+			default:
+				// Other Modifiers Do Not Apply to DNS Filtering
+				return
+			}
+		}
+
+		rule = rule[:i]
+	}
+
+	var flags uint8
+
+	if len(rule) > 2 && rule[0] == '/' && rule[len(rule)-1] == '/' {
+		m.insertPattern(rule, blockExact, exception, important, policy)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(rule, "||"):
+		rule = strings.TrimSuffix(strings.TrimPrefix(rule, "||"), "^")
+		flags = blockExact | blockSubdomains
+
+		if strings.HasPrefix(rule, "*.") {
+			rule = rule[2:]
+			flags = blockSubdomains
+		}
+
+	case strings.HasPrefix(rule, "*."):
+		rule = rule[2:]
+		flags = blockSubdomains
+
+	default:
+		flags = blockExact
+	}
+
+	// Skip URL Rules and Patterns That are Not Plain Domains
+	if rule == "" || strings.ContainsAny(rule, "/^|:$") {
+		return
+	}
+
+	if strings.ContainsAny(rule, "*?") {
+		if flags == blockSubdomains {
+			rule = "*." + rule
+		}
+
+		m.insertPattern(rule, flags, exception, important, policy)
+		return
+	}
+
+	if exception {
+		// Exception Flags are Block Flags Shifted by Two
+		flags <<= 2
+	} else if important {
+		flags |= blockImportant
+	}
+
+	m.insert(rule, flags, policy)
+}
+
+// Rules with Same Rewrite on Same List Share One Policy
+func (m *blockMatcher) rewritePolicy(policy uint16, value string) (uint16, error) {
+	k := string(rune(policy)) + value
+	if idx, found := m.rewrites[k]; found {
+		return idx, nil
+	}
+
+	p, err := m.policies[policy].rewrite(value)
+	if err != nil {
+		return 0, err
+	}
+
+	idx := m.addPolicy(p)
+	m.rewrites[k] = idx
+
+	return idx, nil
+}
+
+func (m *blockMatcher) insertPattern(pattern string, flags uint8, exception bool, important bool, policy uint16) {
+	p, err := compileDomainPattern(pattern, flags&blockSubdomains != 0)
+	if err != nil {
+		return
+	}
+
+	// Patterns are Matched Against Full Name, So Only Exact Flags are Used
+	bp := blockPattern{
+		domainPattern: p,
+		flags:         blockExact,
+		policy:        policy,
+	}
+
+	if exception {
+		bp.flags = allowExact
+		m.exceptions++
+	} else {
+		if important {
+			bp.flags |= blockImportant
+		}
+
+		m.rules++
+	}
+
+	if p.kind == patternGlob {
+		m.globs = append(m.globs, bp)
+	} else {
+		m.regexps = append(m.regexps, bp)
+	}
+}
+
+func (m *blockMatcher) insert(domain string, flags uint8, policy uint16) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" {
+		return
+	}
+
+	node := m.root
+
+	labels := strings.Split(domain, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		if node.children == nil {
+			node.children = make(map[string]*blockNode)
+		}
+
+		child, found := node.children[labels[i]]
+		if !found {
+			child = new(blockNode)
+			node.children[labels[i]] = child
+		}
+
+		node = child
+	}
+
+	if flags&(allowExact|allowSubdomains) != 0 {
+		m.exceptions++
+	} else {
+		m.rules++
+		node.policy = policy
+	}
+
+	node.flags |= flags
+}
+
+// Walk Trie from TLD Down to Full Name Collecting Matching Rules,
+// Then Glob Rules, Then Regex Rules. First of These Having Any Matching Rule
+// Decides, Where Exceptions Win Over Blocks Unless Block is Marked Important.
+// Returns Response Policy of Most Specific Matching Rule, or Nil if Not Blocked
+func (bl *Blocklist) Match(qName string) *BlockPolicy {
+	m := bl.matcher.Load()
+	if m.rules == 0 {
+		return nil
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(qName, "."))
+	node := m.root
+
+	blocked, allowed, important := false, false, false
+	policy, importantPolicy := uint16(0), uint16(0)
+
+	end := len(name)
+	for end > 0 {
+		start := strings.LastIndexByte(name[:end], '.') + 1
+
+		child, found := node.children[name[start:end]]
+		if !found {
+			break
+		}
+
+		node = child
+
+		// Exact Rules Only Apply to Full Name, Subdomain Rules Only to Names Below
+		blockFlag, allowFlag := uint8(blockSubdomains), uint8(allowSubdomains)
+		if start == 0 {
+			blockFlag, allowFlag = blockExact, allowExact
+		}
+
+		if node.flags&blockFlag != 0 {
+			blocked = true
+			policy = node.policy
+
+			if node.flags&blockImportant != 0 {
+				important = true
+				importantPolicy = node.policy
+			}
+		}
+
+		allowed = allowed || node.flags&allowFlag != 0
+
+		end = start - 1
+	}
+
+	if important {
+		return m.policies[importantPolicy]
+	}
+
+	if blocked && !allowed {
+		return m.policies[policy]
+	}
+
+	// Exact and Suffix Rules Take Precedence Over Patterns
+	if allowed {
+		return nil
+	}
+
+	for _, patterns := range [][]blockPattern{m.globs, m.regexps} {
+		if policy, matched := m.matchPatterns(patterns, name); matched {
+			return policy
+		}
+	}
+
+	return nil
+}
+
+// First Matching Block Rule Decides Unless Any Matching Exception
+func (m *blockMatcher) matchPatterns(patterns []blockPattern, name string) (*BlockPolicy, bool) {
+	var blocked *blockPattern
+
+	allowed := false
+	for i := range patterns {
+		p := &patterns[i]
+		if !p.match(name) {
+			continue
+		}
+
+		if p.flags&blockImportant != 0 {
+			return m.policies[p.policy], true
+		}
+
+		if p.flags&allowExact != 0 {
+			allowed = true
+		} else if blocked == nil {
+			blocked = p
+		}
+	}
+
+	if allowed {
+		return nil, true
+	}
+
+	if blocked != nil {
+		return m.policies[blocked.policy], true
+	}
+
+	return nil, false
+}
+
+func (bl *Blocklist) Rules() int {
+	return bl.matcher.Load().rules
+}
+
+func (bl *Blocklist) Exceptions() int {
+	return bl.matcher.Load().exceptions
+}
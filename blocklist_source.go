@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const blocklistDownloadTimeout = 2 * time.Minute
+
+var blocklistMaxSize int64 = 64 << 20
+
+type blocklistSource struct {
+	url          string
+	local        bool
+	path         string
+	etag         string
+	lastModified string
+	interval     time.Duration
+	next         time.Time
+	policy       *BlockPolicy
+}
+
+func newBlocklistSource(sub BlocklistSubscription, cfg BlocklistConfig) (*blocklistSource, error) {
+	u, err := url.Parse(sub.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &blocklistSource{
+		url:      sub.URL,
+		interval: time.Duration(cfg.RefreshInterval) * time.Second,
+	}
+
+	if sub.RefreshInterval > 0 {
+		src.interval = time.Duration(sub.RefreshInterval) * time.Second
+	}
+
+	if src.interval <= 0 {
+		src.interval = 24 * time.Hour
+	}
+
+	// Subscription Response Overrides Only The Fields It Sets
+	if sub.Response != nil {
+		respCfg := cfg.Response
+		if sub.Response.Mode != "" {
+			respCfg.Mode = sub.Response.Mode
+			respCfg.IPv4, respCfg.IPv6 = sub.Response.IPv4, sub.Response.IPv6
+		}
+
+		if sub.Response.TTL > 0 {
+			respCfg.TTL = sub.Response.TTL
+		}
+
+		if sub.Response.ExtendedError != "" {
+			respCfg.ExtendedError = sub.Response.ExtendedError
+		}
+
+		if src.policy, err = NewBlockPolicy(respCfg); err != nil {
+			return nil, err
+		}
+	}
+
+	switch u.Scheme {
+	case "file":
+		// Local Files are Read in Place
+		src.local = true
+		src.path = u.Path
+		if u.Host != "" {
+			src.path = u.Host + u.Path
+		}
+
+		if info, err := os.Stat(src.path); err == nil {
+			src.lastModified = info.ModTime().UTC().Format(http.TimeFormat)
+		}
+
+	case "http", "https":
+		// Remote Lists are Stored on Disk Under Hash of Their URL
+		sum := sha1.Sum([]byte(sub.URL))
+		src.path = filepath.Join(cfg.CacheDir, hex.EncodeToString(sum[:8])+".txt")
+		src.readMeta()
+
+	default:
+		return nil, fmt.Errorf("Error Unsupported Blocklist Scheme '%s'", u.Scheme)
+	}
+
+	return src, nil
+}
+
+func (src *blocklistSource) String() string {
+	return src.url
+}
+
+func (bl *Blocklist) Start() {
+	if len(bl.sources) > 0 {
+		go bl.refreshRoutine()
+	}
+}
+
+func (bl *Blocklist) Stop() {
+	close(bl.stop)
+}
+
+func (bl *Blocklist) refreshRoutine() {
+	// Check Due Sources Every Minute, or More Often for Short Intervals
+	tick := time.Minute
+	for _, src := range bl.sources {
+		tick = min(tick, src.interval)
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	// Refresh All Sources Right Away on Start
+	bl.refresh()
+
+	for {
+		select {
+		case <-ticker.C:
+			bl.refresh()
+
+		case <-bl.stop:
+			// Stop Routine when Stop Signal Recieved
+			return
+		}
+	}
+}
+
+// Fetch Sources That are Due and Recompile Matcher when Any Changed
+func (bl *Blocklist) refresh() {
+	now := time.Now()
+
+	changed := false
+	for _, src := range bl.sources {
+		if now.Before(src.next) {
+			continue
+		}
+
+		updated, err := src.fetch(bl.client)
+		if err != nil {
+			log.Printf("Error Failed to Refresh Blocklist %s: %v", src, err)
+
+			// Retry Failed Source Sooner than Its Interval
+			src.next = now.Add(min(src.interval, 5*time.Minute))
+			continue
+		}
+
+		src.next = now.Add(src.interval)
+		changed = changed || updated
+	}
+
+	if changed {
+		bl.compile()
+		log.Printf("Updated: Blocklist (Rules: %d, Exceptions: %d)", bl.Rules(), bl.Exceptions())
+	}
+}
+
+// Download Source Unless Unchanged Since Last Fetch,
+// Returns Whether Content Has Changed
+func (src *blocklistSource) fetch(client *http.Client) (bool, error) {
+	if src.local {
+		info, err := os.Stat(src.path)
+		if err != nil {
+			return false, err
+		}
+
+		modified := info.ModTime().UTC().Format(http.TimeFormat)
+		if modified == src.lastModified {
+			return false, nil
+		}
+
+		src.lastModified = modified
+		return true, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), blocklistDownloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", src.url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// Conditional Request Only if Last Good Copy is Still on Disk
+	if _, err := os.Stat(src.path); err == nil {
+		if src.etag != "" {
+			req.Header.Set("If-None-Match", src.etag)
+		}
+
+		if src.lastModified != "" {
+			req.Header.Set("If-Modified-Since", src.lastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf("Error Blocklist Server Returned %d", resp.StatusCode)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(src.path), 0755); err != nil {
+		return false, err
+	}
+
+	// Write to Temporary File First, So Failed Download
+	// Never Replaces The Last Good Copy
+	tmpFile := src.path + ".tmp"
+
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := io.Copy(file, io.LimitReader(resp.Body, blocklistMaxSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && n > blocklistMaxSize {
+		err = fmt.Errorf("Error Blocklist is Larger than %d Bytes", blocklistMaxSize)
+	}
+
+	if err != nil {
+		os.Remove(tmpFile)
+		return false, err
+	}
+
+	if err := os.Rename(tmpFile, src.path); err != nil {
+		os.Remove(tmpFile)
+		return false, err
+	}
+
+	src.etag = resp.Header.Get("ETag")
+	src.lastModified = resp.Header.Get("Last-Modified")
+	src.writeMeta()
+
+	return true, nil
+}
+
+// ETag and Last-Modified are Kept Next to Downloaded Copy
+// To Avoid Downloading Unchanged List After Restart
+func (src *blocklistSource) readMeta() {
+	file, err := os.Open(src.path + ".meta")
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, value, found := strings.Cut(scanner.Text(), ": ")
+		if !found {
+			continue
+		}
+
+		switch name {
+		case "ETag":
+			src.etag = value
+		case "Last-Modified":
+			src.lastModified = value
+		}
+	}
+}
+
+func (src *blocklistSource) writeMeta() {
+	meta := fmt.Sprintf("URL: %s\nETag: %s\nLast-Modified: %s\n", src.url, src.etag, src.lastModified)
+
+	if err := os.WriteFile(src.path+".meta", []byte(meta), 0644); err != nil {
+		log.Printf("Error Failed to Save Blocklist Metadata %s: %v", src, err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Blocklist Server Answering Conditional Requests, Status Forces
+// Error Response and Body Replaces List Content when Set
+type testListServer struct {
+	mu       sync.Mutex
+	body     string
+	etag     string
+	modified string
+	status   int
+	headers  []http.Header
+}
+
+func (s *testListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.headers = append(s.headers, r.Header.Clone())
+
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == s.etag || r.Header.Get("If-Modified-Since") == s.modified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("ETag", s.etag)
+	w.Header().Set("Last-Modified", s.modified)
+	w.Write([]byte(s.body))
+}
+
+func (s *testListServer) lastHeader() http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.headers[len(s.headers)-1]
+}
+
+func newTestListServer(t *testing.T, body string) (*testListServer, *httptest.Server) {
+	t.Helper()
+
+	ls := &testListServer{
+		body:     body,
+		etag:     `"v1"`,
+		modified: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat),
+	}
+
+	srv := httptest.NewServer(ls)
+	t.Cleanup(srv.Close)
+
+	return ls, srv
+}
+
+func newTestSource(t *testing.T, url string, cacheDir string) *blocklistSource {
+	t.Helper()
+
+	src, err := newBlocklistSource(BlocklistSubscription{URL: url}, BlocklistConfig{CacheDir: cacheDir})
+	if err != nil {
+		t.Fatalf("newBlocklistSource: %v", err)
+	}
+
+	return src
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	return string(data)
+}
+
+func TestBlocklistSourceConditionalFetch(t *testing.T) {
+	ls, srv := newTestListServer(t, "||ads.example.com^\n")
+	cacheDir := t.TempDir()
+
+	src := newTestSource(t, srv.URL+"/list.txt", cacheDir)
+
+	updated, err := src.fetch(srv.Client())
+	if err != nil || !updated {
+		t.Fatalf("first fetch = %v, %v, want true, nil", updated, err)
+	}
+
+	if h := ls.lastHeader(); h.Get("If-None-Match") != "" || h.Get("If-Modified-Since") != "" {
+		t.Errorf("first fetch sent conditional headers: %v", h)
+	}
+
+	if got := readFile(t, src.path); got != ls.body {
+		t.Errorf("stored list = %q, want %q", got, ls.body)
+	}
+
+	updated, err = src.fetch(srv.Client())
+	if err != nil || updated {
+		t.Fatalf("second fetch = %v, %v, want false, nil", updated, err)
+	}
+
+	h := ls.lastHeader()
+	if got := h.Get("If-None-Match"); got != ls.etag {
+		t.Errorf("If-None-Match = %q, want %q", got, ls.etag)
+	}
+
+	if got := h.Get("If-Modified-Since"); got != ls.modified {
+		t.Errorf("If-Modified-Since = %q, want %q", got, ls.modified)
+	}
+
+	if got := readFile(t, src.path); got != ls.body {
+		t.Errorf("stored list after 304 = %q, want %q", got, ls.body)
+	}
+
+	// Validators are Read Back from Metadata After Restart
+	restarted := newTestSource(t, srv.URL+"/list.txt", cacheDir)
+	if restarted.etag != ls.etag || restarted.lastModified != ls.modified {
+		t.Errorf("restored validators = %q, %q, want %q, %q", restarted.etag, restarted.lastModified, ls.etag, ls.modified)
+	}
+
+	updated, err = restarted.fetch(srv.Client())
+	if err != nil || updated {
+		t.Errorf("fetch after restart = %v, %v, want false, nil", updated, err)
+	}
+}
+
+func TestBlocklistSourceNoConditionalWithoutCopy(t *testing.T) {
+	ls, srv := newTestListServer(t, "ads.example.com\n")
+
+	src := newTestSource(t, srv.URL, t.TempDir())
+	src.etag = ls.etag
+	src.lastModified = ls.modified
+
+	updated, err := src.fetch(srv.Client())
+	if err != nil || !updated {
+		t.Fatalf("fetch = %v, %v, want true, nil", updated, err)
+	}
+
+	if h := ls.lastHeader(); h.Get("If-None-Match") != "" || h.Get("If-Modified-Since") != "" {
+		t.Errorf("fetch without stored copy sent conditional headers: %v", h)
+	}
+}
+
+func TestBlocklistSourceSizeLimit(t *testing.T) {
+	maxSize := blocklistMaxSize
+	blocklistMaxSize = 16
+	t.Cleanup(func() { blocklistMaxSize = maxSize })
+
+	_, srv := newTestListServer(t, strings.Repeat("a", 17))
+
+	src := newTestSource(t, srv.URL, t.TempDir())
+
+	if _, err := src.fetch(srv.Client()); err == nil {
+		t.Fatal("fetch of oversized list succeeded")
+	}
+
+	for _, path := range []string{src.path, src.path + ".tmp"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s exists after oversized download", path)
+		}
+	}
+}
+
+func TestBlocklistSourceKeepsLastGoodCopy(t *testing.T) {
+	ls, srv := newTestListServer(t, "||ads.example.com^\n")
+
+	src := newTestSource(t, srv.URL, t.TempDir())
+
+	if _, err := src.fetch(srv.Client()); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+
+	good := ls.body
+
+	ls.mu.Lock()
+	ls.status = http.StatusInternalServerError
+	ls.mu.Unlock()
+
+	if _, err := src.fetch(srv.Client()); err == nil {
+		t.Fatal("fetch with server error succeeded")
+	}
+
+	if got := readFile(t, src.path); got != good {
+		t.Errorf("stored list after server error = %q, want %q", got, good)
+	}
+
+	// Connection Dropped in The Middle of Changed List
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		w.Write([]byte("||other.example.com^\n"))
+	}))
+	defer broken.Close()
+
+	src.url = broken.URL
+
+	if _, err := src.fetch(broken.Client()); err == nil {
+		t.Fatal("fetch of truncated list succeeded")
+	}
+
+	if got := readFile(t, src.path); got != good {
+		t.Errorf("stored list after truncated download = %q, want %q", got, good)
+	}
+}
+
+func TestBlocklistRefreshSubscription(t *testing.T) {
+	ls, srv := newTestListServer(t, "||ads.example.com^\n")
+
+	bl, err := NewBlocklist(BlocklistConfig{
+		Enable:        true,
+		CacheDir:      t.TempDir(),
+		Subscriptions: []BlocklistSubscription{{URL: srv.URL}},
+	}, UpstreamConfig{})
+	if err != nil {
+		t.Fatalf("NewBlocklist: %v", err)
+	}
+
+	bl.client = srv.Client()
+
+	if bl.Match("ads.example.com.") != nil {
+		t.Fatal("subscription rule matched before download")
+	}
+
+	bl.refresh()
+
+	if bl.Match("www.ads.example.com.") == nil {
+		t.Fatal("subscription rule not matched after refresh")
+	}
+
+	ls.mu.Lock()
+	ls.status = http.StatusBadGateway
+	ls.mu.Unlock()
+
+	bl.sources[0].next = time.Time{}
+	bl.refresh()
+
+	if bl.Match("ads.example.com.") == nil {
+		t.Error("subscription rule lost after failed refresh")
+	}
+
+	if !bl.sources[0].next.After(time.Now()) {
+		t.Error("failed source not scheduled for retry")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"testing"
+)
+
+func newTestBlocklist(t *testing.T, rules ...string) *Blocklist {
+	t.Helper()
+
+	bl, err := NewBlocklist(BlocklistConfig{Enable: true, Rules: rules}, UpstreamConfig{})
+	if err != nil {
+		t.Fatalf("NewBlocklist: %v", err)
+	}
+
+	return bl
+}
+
+func TestBlocklistMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []string
+		blocked []string
+		allowed []string
+	}{
+		{
+			name:    "hosts",
+			rules:   []string{"0.0.0.0 ads.example.com tracker.example.com # comment", "127.0.0.1 localhost"},
+			blocked: []string{"ads.example.com.", "TRACKER.example.com."},
+			allowed: []string{"www.ads.example.com.", "localhost.", "example.com."},
+		},
+		{
+			name:    "domain list",
+			rules:   []string{"ads.example.com", "# comment", "! comment"},
+			blocked: []string{"ads.example.com.", "ads.example.com"},
+			allowed: []string{"www.ads.example.com.", "comment."},
+		},
+		{
+			name:    "subdomains only",
+			rules:   []string{"*.ads.example.com"},
+			blocked: []string{"www.ads.example.com.", "a.b.ads.example.com."},
+			allowed: []string{"ads.example.com."},
+		},
+		{
+			name:    "adblock",
+			rules:   []string{"||ads.example.com^"},
+			blocked: []string{"ads.example.com.", "www.ads.example.com."},
+			allowed: []string{"badads.example.com.", "example.com."},
+		},
+		{
+			name:    "adblock subdomains only",
+			rules:   []string{"||*.ads.example.com^"},
+			blocked: []string{"www.ads.example.com."},
+			allowed: []string{"ads.example.com."},
+		},
+		{
+			name:    "exception",
+			rules:   []string{"||example.com^", "@@||www.example.com^"},
+			blocked: []string{"example.com.", "ads.example.com."},
+			allowed: []string{"www.example.com.", "cdn.www.example.com."},
+		},
+		{
+			name:    "important overrides exception",
+			rules:   []string{"||example.com^$important", "@@||www.example.com^"},
+			blocked: []string{"www.example.com."},
+		},
+		{
+			name:    "unsupported rules",
+			rules:   []string{"||example.com/ads^", "||example.com^$third-party", "[Adblock Plus 2.0]", "not-an-ip example.com"},
+			allowed: []string{"example.com."},
+		},
+		{
+			name:    "glob",
+			rules:   []string{"||ad*.example.com^", "tr?ck.example.org"},
+			blocked: []string{"ads.example.com.", "www.ad1.example.com.", "track.example.org."},
+			allowed: []string{"www.example.com.", "trck.example.org.", "www.track.example.org."},
+		},
+		{
+			name:    "regex",
+			rules:   []string{`/^ad[0-9]+\./`},
+			blocked: []string{"ad1.example.com.", "AD22.example.net."},
+			allowed: []string{"ads.example.com.", "www.ad1.example.com."},
+		},
+		{
+			name:    "exact rule exception before pattern",
+			rules:   []string{"||ad*.example.com^", "@@||ads.example.com^"},
+			blocked: []string{"adserver.example.com."},
+			allowed: []string{"ads.example.com."},
+		},
+		{
+			name:    "pattern exception",
+			rules:   []string{"/^ad/", "@@/^ads\\./"},
+			blocked: []string{"adserver.example.com."},
+			allowed: []string{"ads.example.com."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bl := newTestBlocklist(t, tt.rules...)
+
+			for _, name := range tt.blocked {
+				if bl.Match(name) == nil {
+					t.Errorf("Match(%q) = nil, want blocked", name)
+				}
+			}
+
+			for _, name := range tt.allowed {
+				if p := bl.Match(name); p != nil {
+					t.Errorf("Match(%q) = %v, want nil", name, p)
+				}
+			}
+		})
+	}
+}
+
+func TestBlocklistRuleCounts(t *testing.T) {
+	bl := newTestBlocklist(t, "||ads.example.com^", "0.0.0.0 a.example.com b.example.com", "@@||www.ads.example.com^", "/^ad/")
+
+	if got := bl.Rules(); got != 4 {
+		t.Errorf("Rules() = %d, want 4", got)
+	}
+
+	if got := bl.Exceptions(); got != 1 {
+		t.Errorf("Exceptions() = %d, want 1", got)
+	}
+}
+
+func TestBlocklistDNSRewrite(t *testing.T) {
+	bl := newTestBlocklist(t,
+		"||refused.example.com^$dnsrewrite=REFUSED",
+		"||sinkhole.example.com^$dnsrewrite=10.0.0.1",
+		"||invalid.example.com^$dnsrewrite=bogus",
+		"||plain.example.com^",
+	)
+
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{"refused.example.com.", "refused"},
+		{"sinkhole.example.com.", "sinkhole"},
+		{"plain.example.com.", "nxdomain"},
+	}
+
+	for _, tt := range tests {
+		p := bl.Match(tt.name)
+		if p == nil || p.String() != tt.mode {
+			t.Errorf("Match(%q) = %v, want %s", tt.name, p, tt.mode)
+		}
+	}
+
+	if p := bl.Match("invalid.example.com."); p != nil {
+		t.Errorf("rule with invalid rewrite matched with %v", p)
+	}
+}
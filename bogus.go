@@ -1,48 +1,32 @@
 package main
 
 import (
-	"net"
-
 	"github.com/miekg/dns"
 )
 
-func checkBogusNXDomain(resp *dns.Msg) {
+// Check if Upstream Answered with Any of Bogus NXDomain Addresses
+func isBogusNXDomain(resp *dns.Msg) bool {
 	if resp == nil || len(bogusNXDomains) == 0 {
-		return
+		return false
 	}
 
-	hasBogus := false
 	for _, rr := range resp.Answer {
 		switch v := rr.(type) {
 		case *dns.A:
 			for _, bip := range bogusNXDomains {
 				if v.A.Equal(bip) {
-					hasBogus = true
-					break
+					return true
 				}
 			}
 
 		case *dns.AAAA:
 			for _, bip := range bogusNXDomains {
 				if v.AAAA.Equal(bip) {
-					hasBogus = true
-					break
+					return true
 				}
 			}
 		}
-
-		if hasBogus {
-			break
-		}
 	}
 
-	if hasBogus {
-		for _, rr := range resp.Answer {
-			if a, ok := rr.(*dns.A); ok {
-				a.A = net.IPv4zero
-			} else if aaaa, ok := rr.(*dns.AAAA); ok {
-				aaaa.AAAA = net.IPv6zero
-			}
-		}
-	}
+	return false
 }
@@ -3,16 +3,25 @@ package main
 import (
 	"container/list"
 	"hash/fnv"
+	"log"
+	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
 type CacheItem struct {
-	Key     string
-	Msg     *dns.Msg
-	Expires time.Time
+	Key         string
+	Family      uint16
+	Scope       uint8
+	Msg         *dns.Msg
+	TTL         time.Duration
+	Expires     time.Time
+	hits        atomic.Uint32
+	prefetching atomic.Bool
 }
 
 type CacheShard struct {
@@ -23,17 +32,37 @@ type CacheShard struct {
 }
 
 type DNSCache struct {
-	enabled    bool
-	shards     []*CacheShard
-	shardCount uint64
-	shardMask  uint64
-	defaultTTL time.Duration
-	minTTL     time.Duration
-	negTTL     time.Duration
-	stop       chan struct{}
+	enabled         bool
+	shards          []*CacheShard
+	shardCount      uint64
+	shardMask       uint64
+	defaultTTL      time.Duration
+	minTTL          time.Duration
+	maxTTL          time.Duration
+	negTTL          time.Duration
+	maxNegTTL       time.Duration
+	servFailTTL     time.Duration
+	rules           map[string]CacheRule
+	serveStale      bool
+	staleMaxAge     time.Duration
+	staleTimeout    time.Duration
+	prefetch        bool
+	prefetchPct     int64
+	prefetchHits    uint32
+	prefetchSem     chan struct{}
+	ecsScopes       [2][3]atomic.Uint64
+	persistFile     string
+	persistInterval time.Duration
+	persistMu       sync.Mutex
+	stop            chan struct{}
+	routines        sync.WaitGroup
 }
 
-func NewCache(size int, shards int, minTTL int, negTTL int) *DNSCache {
+// TTL of Stale Answers as Recommended by RFC 8767
+const staleTTL = 30
+
+func NewCache(cfg CacheConfig) *DNSCache {
+	shards := cfg.Shards
 	if shards < 1 {
 		shards = 256
 	}
@@ -41,18 +70,49 @@ func NewCache(size int, shards int, minTTL int, negTTL int) *DNSCache {
 	shardsCount := nextPowerOfTwo(shards)
 
 	c := &DNSCache{
-		enabled:    size > 0,
-		shards:     make([]*CacheShard, shardsCount),
-		shardCount: uint64(shardsCount),
-		shardMask:  uint64(shardsCount - 1),
-		defaultTTL: 60 * time.Second,
-		minTTL:     time.Duration(minTTL) * time.Second,
-		negTTL:     time.Duration(negTTL) * time.Second,
-		stop:       make(chan struct{}),
+		enabled:     cfg.Size > 0,
+		shards:      make([]*CacheShard, shardsCount),
+		shardCount:  uint64(shardsCount),
+		shardMask:   uint64(shardsCount - 1),
+		defaultTTL:  60 * time.Second,
+		minTTL:      time.Duration(cfg.MinTTL) * time.Second,
+		maxTTL:      time.Duration(cfg.MaxTTL) * time.Second,
+		negTTL:      time.Duration(cfg.NegTTL) * time.Second,
+		maxNegTTL:   time.Duration(cfg.MaxNegTTL) * time.Second,
+		servFailTTL: time.Duration(cfg.ServFailTTL) * time.Second,
+		rules:       make(map[string]CacheRule),
+		stop:        make(chan struct{}),
+	}
+
+	for _, rule := range cfg.Rules {
+		c.rules[dns.CanonicalName(rule.Domain)] = rule
+	}
+
+	if cfg.ServeStale.Enable {
+		c.serveStale = true
+		c.staleMaxAge = time.Duration(cfg.ServeStale.MaxAge) * time.Second
+		c.staleTimeout = time.Duration(cfg.ServeStale.ClientTimeout) * time.Millisecond
+	}
+
+	if cfg.Prefetch.Enable {
+		concurrency := cfg.Prefetch.Concurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+
+		c.prefetch = true
+		c.prefetchPct = int64(cfg.Prefetch.Threshold)
+		c.prefetchHits = uint32(cfg.Prefetch.MinHits)
+		c.prefetchSem = make(chan struct{}, concurrency)
+	}
+
+	if cfg.Persist.Enable {
+		c.persistFile = cfg.Persist.File
+		c.persistInterval = time.Duration(cfg.Persist.Interval) * time.Second
 	}
 
 	// Count Cache Capacity Per-Shard
-	shardCapacity := size / shardsCount
+	shardCapacity := cfg.Size / shardsCount
 	if shardCapacity < 1 {
 		shardCapacity = 1
 	}
@@ -66,15 +126,89 @@ func NewCache(size int, shards int, minTTL int, negTTL int) *DNSCache {
 		}
 	}
 
-	if c.enabled {
-		go c.cleanupRoutine()
+	return c
+}
+
+// Cache Key of Question Including DNSSEC OK and Checking Disabled Bits,
+// So DNSSEC and Non-DNSSEC Answers are Cached Separately. Client Groups
+// with Their Own Upstreams Have Their Own View of The Cache
+func key(r *dns.Msg, view string) string {
+	q := r.Question[0]
+
+	flags := 0
+	if opt := r.IsEdns0(); opt != nil && opt.Do() {
+		flags |= 1
 	}
 
-	return c
+	if r.CheckingDisabled {
+		flags |= 2
+	}
+
+	k := q.Name + string(rune(q.Qtype)) + string(rune(q.Qclass)) + string(rune(flags))
+	if view != "" {
+		k = viewKey(view) + k
+	}
+
+	return k
 }
 
-func key(q dns.Question) string {
-	return q.Name + string(rune(q.Qtype)) + string(rune(q.Qclass))
+// Zero Byte Never Appears in Name Presentation Format,
+// So View Prefix Can Not Collide with Any Question Name
+func viewKey(view string) string {
+	return "\x00" + view + "\x00"
+}
+
+// Key Suffix of Client Subnet Reduced to Scope Prefix Length,
+// Scope 0 Answers are Valid for All Clients so They Have No Suffix
+func ecsKey(family uint16, addr net.IP, scope uint8) string {
+	if scope == 0 {
+		return ""
+	}
+
+	bits := 32
+	if family == 2 {
+		bits = 128
+	}
+
+	if int(scope) > bits {
+		scope = uint8(bits)
+	}
+
+	masked := addr.Mask(net.CIDRMask(int(scope), bits))
+	if masked == nil {
+		return ""
+	}
+
+	return "/" + string(rune(family)) + string(rune(scope)) + string(masked)
+}
+
+// Key for Request Coalescing, Queries from Different
+// Client Subnets Must Not Share Upstream Request
+func requestKey(r *dns.Msg, view string) string {
+	k := key(r, view)
+
+	if ecs := findECS(r); ecs != nil {
+		k += ecsKey(ecs.Family, ecs.Address, ecs.SourceNetmask)
+	}
+
+	return k
+}
+
+// Record ECS Scope Prefix Length Seen from Upstream
+func (c *DNSCache) addScope(family uint16, scope uint8) {
+	if family != 1 && family != 2 {
+		return
+	}
+
+	c.ecsScopes[family-1][scope/64].Or(1 << (scope % 64))
+}
+
+func (c *DNSCache) hasScope(family uint16, scope uint8) bool {
+	if family != 1 && family != 2 {
+		return false
+	}
+
+	return c.ecsScopes[family-1][scope/64].Load()&(1<<(scope%64)) != 0
 }
 
 func (c *DNSCache) getShard(key string) *CacheShard {
@@ -84,12 +218,130 @@ func (c *DNSCache) getShard(key string) *CacheShard {
 	return c.shards[h.Sum64()&c.shardMask]
 }
 
-func (c *DNSCache) Get(r *dns.Msg) *dns.Msg {
+func (c *DNSCache) Get(r *dns.Msg, view string) *dns.Msg {
+	now := time.Now()
+
+	item := c.lookup(r, view)
+	if item == nil || now.After(item.Expires) {
+		return nil
+	}
+
+	if c.prefetch {
+		c.checkPrefetch(r, view, item, now)
+	}
+
+	// Count Down TTLs by Time Spent in Cache
+	elapsed := uint32(now.Sub(item.Expires.Add(-item.TTL)) / time.Second)
+
+	msg := item.Msg.Copy()
+	forEachRR(msg, func(hdr *dns.RR_Header) {
+		if hdr.Ttl > elapsed {
+			hdr.Ttl -= elapsed
+		} else {
+			hdr.Ttl = 0
+		}
+	})
+
+	return msg
+}
+
+// Refresh Popular Entry in Background when its
+// Remaining TTL Falls Below Prefetch Threshold
+func (c *DNSCache) checkPrefetch(r *dns.Msg, view string, item *CacheItem, now time.Time) {
+	hits := item.hits.Add(1)
+	if hits < c.prefetchHits {
+		return
+	}
+
+	window := time.Duration(int64(item.TTL) * c.prefetchPct / 100)
+	if item.Expires.Sub(now) > window {
+		return
+	}
+
+	// Only One Prefetch per Entry
+	if !item.prefetching.CompareAndSwap(false, true) {
+		return
+	}
+
+	// Skip when Concurrent Prefetch Limit is Reached,
+	// Later Hits May Try Again
+	select {
+	case c.prefetchSem <- struct{}{}:
+	default:
+		item.prefetching.Store(false)
+		return
+	}
+
+	q := r.Copy()
+	q.Id = dns.Id()
+
+	go func() {
+		defer func() { <-c.prefetchSem }()
+
+		// Let Later Hits Try Again Unless Refreshed Answer Replaced
+		// The Entry, Which is Not The Case for Errors or SERVFAIL
+		// Kept Out of Cache While Serving Stale
+		defer item.prefetching.Store(false)
+
+		configLock.RLock()
+		defer configLock.RUnlock()
+
+		if _, err := resolveUpstream(q, dnsClients.Group(view)); err != nil {
+			log.Printf("Error Failed to Prefetch %s: %v", q.Question[0].Name, err)
+		}
+	}()
+}
+
+// Return Expired Answer That is Still Within Max Stale Age
+// With Its TTLs Lowered to Stale TTL (RFC 8767)
+func (c *DNSCache) GetStale(r *dns.Msg, view string) *dns.Msg {
+	if !c.serveStale {
+		return nil
+	}
+
+	item := c.lookup(r, view)
+	if item == nil || !time.Now().After(item.Expires) || item.Msg.Rcode == dns.RcodeServerFailure {
+		return nil
+	}
+
+	msg := item.Msg.Copy()
+	forEachRR(msg, func(hdr *dns.RR_Header) {
+		hdr.Ttl = staleTTL
+	})
+
+	return msg
+}
+
+// Time to Wait for Upstream Before Answering with Stale Entry
+func (c *DNSCache) StaleTimeout() time.Duration {
+	return c.staleTimeout
+}
+
+// Find Entry for Request, With ECS The Longest Scope Seen from Upstream
+// That Covers Client Subnet is Tried First, Down to Scope 0 (RFC 7871)
+func (c *DNSCache) lookup(r *dns.Msg, view string) *CacheItem {
 	if !c.enabled || len(r.Question) == 0 {
 		return nil
 	}
 
-	k := key(r.Question[0])
+	k := key(r, view)
+
+	if ecs := findECS(r); ecs != nil {
+		for scope := int(ecs.SourceNetmask); scope > 0; scope-- {
+			if !c.hasScope(ecs.Family, uint8(scope)) {
+				continue
+			}
+
+			if item := c.lookupKey(k + ecsKey(ecs.Family, ecs.Address, uint8(scope))); item != nil {
+				return item
+			}
+		}
+	}
+
+	return c.lookupKey(k)
+}
+
+func (c *DNSCache) lookupKey(k string) *CacheItem {
 	shard := c.getShard(k)
 
 	shard.mu.Lock()
@@ -100,8 +352,9 @@ func (c *DNSCache) Get(r *dns.Msg) *dns.Msg {
 		return nil
 	}
 
+	// Expired Items are Kept Until Max Stale Age Passed
 	item := elem.Value.(*CacheItem)
-	if time.Now().After(item.Expires) {
+	if time.Now().After(item.Expires.Add(c.staleMaxAge)) {
 		shard.ll.Remove(elem)
 		delete(shard.store, k)
 
@@ -110,21 +363,85 @@ func (c *DNSCache) Get(r *dns.Msg) *dns.Msg {
 
 	shard.ll.MoveToFront(elem)
 
-	return item.Msg.Copy()
+	return item
 }
 
-func (c *DNSCache) Set(r *dns.Msg) {
-	if !c.enabled || len(r.Question) == 0 {
+// Store Response to Request r, Keyed by Request Question and Flags
+// Plus Client Subnet Reduced to ECS Scope Returned by Upstream
+func (c *DNSCache) Set(req *dns.Msg, r *dns.Msg, view string) {
+	if !c.enabled || len(req.Question) == 0 || len(r.Question) == 0 {
+		return
+	}
+
+	// Truncated Answer is Incomplete, Client Retries Over TCP
+	if r.Truncated {
+		return
+	}
+
+	rule, hasRule := c.getRule(r.Question[0].Name)
+	if hasRule && rule.NoCache {
 		return
 	}
 
 	ttl := c.defaultTTL
 
-	if r.Rcode == dns.RcodeNameError || r.Rcode == dns.RcodeServerFailure {
-		// Negative Caching
-		ttl = c.negTTL
-	} else {
-		// Positive Caching
+	// TTLs are Rewritten in Place, So Fresh Answer
+	// Sent to Client Matches The Cached One
+	switch {
+	case r.Rcode == dns.RcodeServerFailure:
+		// SERVFAIL Has Its Own Short TTL (RFC 9520)
+		if c.servFailTTL <= 0 {
+			return
+		}
+
+		ttl = c.servFailTTL
+
+	case isNegativeResponse(r):
+		// Negative Caching for NXDOMAIN and NODATA (RFC 2308)
+		ttl = c.negativeTTL(r)
+		if ttl <= 0 {
+			return
+		}
+
+		// SOA TTL Tells Client How Long to Cache Negative Answer
+		negTTL := uint32(ttl / time.Second)
+		for _, rr := range r.Ns {
+			if soa, ok := rr.(*dns.SOA); ok && soa.Hdr.Ttl > negTTL {
+				soa.Hdr.Ttl = negTTL
+			}
+		}
+
+	default:
+		// Positive Caching, Clamp TTLs Into Configured Range
+		// So They Count Down Together with Cache Entry
+		minTTL := uint32(c.minTTL / time.Second)
+		maxTTL := uint32(c.maxTTL / time.Second)
+
+		if hasRule {
+			if rule.MinTTL > 0 {
+				minTTL = uint32(rule.MinTTL)
+			}
+
+			if rule.MaxTTL > 0 {
+				maxTTL = uint32(rule.MaxTTL)
+			}
+
+			if rule.TTL > 0 {
+				minTTL = uint32(rule.TTL)
+				maxTTL = uint32(rule.TTL)
+			}
+		}
+
+		forEachRR(r, func(hdr *dns.RR_Header) {
+			if hdr.Ttl < minTTL {
+				hdr.Ttl = minTTL
+			}
+
+			if maxTTL > 0 && hdr.Ttl > maxTTL {
+				hdr.Ttl = maxTTL
+			}
+		})
+
 		minFound := uint32(0)
 		for _, rr := range r.Answer {
 			if minFound == 0 || rr.Header().Ttl < minFound {
@@ -135,27 +452,51 @@ func (c *DNSCache) Set(r *dns.Msg) {
 		if minFound > 0 {
 			ttl = time.Duration(minFound) * time.Second
 		}
+	}
+
+	msg := r.Copy()
+
+	item := &CacheItem{
+		Key:     key(req, view),
+		Msg:     msg,
+		TTL:     ttl,
+		Expires: time.Now().Add(ttl),
+	}
+
+	if ecs := findECS(req); ecs != nil {
+		// Scope Longer than Source Prefix is Treated as Source Prefix,
+		// No ECS in Response Means Answer is Valid for All Clients
+		if respECS := findECS(r); respECS != nil {
+			item.Scope = min(respECS.SourceScope, ecs.SourceNetmask)
+		}
 
-		if ttl < c.minTTL {
-			ttl = c.minTTL
+		if item.Scope > 0 {
+			item.Family = ecs.Family
+			item.Key += ecsKey(ecs.Family, ecs.Address, item.Scope)
 		}
 	}
 
-	k := key(r.Question[0])
-	newItem := &CacheItem{
-		Key:     k,
-		Msg:     r.Copy(),
-		Expires: time.Now().Add(ttl),
+	c.add(item)
+}
+
+func (c *DNSCache) add(newItem *CacheItem) {
+	if newItem.Scope > 0 {
+		c.addScope(newItem.Family, newItem.Scope)
 	}
 
-	shard := c.getShard(k)
+	shard := c.getShard(newItem.Key)
 
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
 	// Check if Cache Item Already Exist
 	// If Exist Update it in Linked List
-	if elem, found := shard.store[k]; found {
+	if elem, found := shard.store[newItem.Key]; found {
+		// Keep Stale Answer Rather than Replacing it with SERVFAIL
+		if c.serveStale && newItem.Msg.Rcode == dns.RcodeServerFailure {
+			return
+		}
+
 		elem.Value = newItem
 		shard.ll.MoveToFront(elem)
 		return
@@ -173,10 +514,187 @@ func (c *DNSCache) Set(r *dns.Msg) {
 
 	// Add Cache Item in to Linked List
 	elem := shard.ll.PushFront(newItem)
-	shard.store[k] = elem
+	shard.store[newItem.Key] = elem
+}
+
+// Move Live Entries from Old Cache, Used when Cache is
+// Resized or Re-Sharded on Reload. Returns Number of Moved Entries
+func (c *DNSCache) MoveFrom(old *DNSCache) int {
+	if !c.enabled || !old.enabled {
+		return 0
+	}
+
+	now := time.Now()
+
+	moved := 0
+	for _, item := range old.items() {
+		if now.After(item.Expires.Add(c.staleMaxAge)) {
+			continue
+		}
+
+		c.add(item)
+		moved++
+	}
+
+	return moved
+}
+
+// Remove Entries for Exact Names, Names Under Domain Suffixes or
+// Names Matching Patterns, Returns Number of Removed Entries
+func (c *DNSCache) Invalidate(names []string, suffixes []string, patterns []string) int {
+	if !c.enabled {
+		return 0
+	}
+
+	var compiled []*domainPattern
+	for _, pattern := range patterns {
+		if p, err := compileDomainPattern(pattern, false); err == nil {
+			compiled = append(compiled, p)
+		}
+	}
+
+	exact := make(map[string]bool, len(names))
+	for _, name := range names {
+		exact[dns.CanonicalName(name)] = true
+	}
+
+	for i, suffix := range suffixes {
+		suffixes[i] = dns.CanonicalName(suffix)
+	}
+
+	removed := 0
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+
+		var next *list.Element
+		for e := shard.ll.Front(); e != nil; e = next {
+			next = e.Next()
+			item := e.Value.(*CacheItem)
+
+			if len(item.Msg.Question) == 0 {
+				continue
+			}
+
+			qName := dns.CanonicalName(item.Msg.Question[0].Name)
+
+			match := exact[qName]
+			for _, suffix := range suffixes {
+				if strings.HasSuffix(qName, "."+suffix) || qName == suffix {
+					match = true
+					break
+				}
+			}
+
+			for i := 0; i < len(compiled) && !match; i++ {
+				match = compiled[i].match(strings.TrimSuffix(qName, "."))
+			}
+
+			if match {
+				shard.ll.Remove(e)
+				delete(shard.store, item.Key)
+				removed++
+			}
+		}
+
+		shard.mu.Unlock()
+	}
+
+	return removed
+}
+
+// Remove All Entries of Client Group Views, Returns Number of Removed Entries
+func (c *DNSCache) InvalidateViews(views []string) int {
+	if !c.enabled || len(views) == 0 {
+		return 0
+	}
+
+	removed := 0
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+
+		var next *list.Element
+		for e := shard.ll.Front(); e != nil; e = next {
+			next = e.Next()
+			item := e.Value.(*CacheItem)
+
+			for _, view := range views {
+				if strings.HasPrefix(item.Key, viewKey(view)) {
+					shard.ll.Remove(e)
+					delete(shard.store, item.Key)
+					removed++
+
+					break
+				}
+			}
+		}
+
+		shard.mu.Unlock()
+	}
+
+	return removed
+}
+
+// Find TTL Rule with Longest Matching Domain Suffix
+func (c *DNSCache) getRule(qName string) (CacheRule, bool) {
+	var bestLen int
+
+	var bestMatch CacheRule
+	found := false
+
+	qName = dns.CanonicalName(qName)
+
+	for domain, rule := range c.rules {
+		if strings.HasSuffix(qName, "."+domain) || qName == domain {
+			if len(domain) > bestLen {
+				bestLen = len(domain)
+
+				bestMatch = rule
+				found = true
+			}
+		}
+	}
+
+	return bestMatch, found
+}
+
+// NXDOMAIN or NODATA (NOERROR Without Answer)
+func isNegativeResponse(r *dns.Msg) bool {
+	return r.Rcode == dns.RcodeNameError || (r.Rcode == dns.RcodeSuccess && len(r.Answer) == 0)
+}
+
+// Negative TTL is The Minimum of SOA TTL and SOA MINIMUM Field,
+// Falling Back to Configured Negative TTL when There is No SOA
+func (c *DNSCache) negativeTTL(r *dns.Msg) time.Duration {
+	ttl := c.negTTL
+
+	for _, rr := range r.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl = time.Duration(min(soa.Hdr.Ttl, soa.Minttl)) * time.Second
+			break
+		}
+	}
+
+	if c.maxNegTTL > 0 && ttl > c.maxNegTTL {
+		ttl = c.maxNegTTL
+	}
+
+	return ttl
+}
+
+// Call fn for Every Record Header Except OPT Pseudo-Record
+func forEachRR(m *dns.Msg, fn func(hdr *dns.RR_Header)) {
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				fn(rr.Header())
+			}
+		}
+	}
 }
 
 func (c *DNSCache) cleanupRoutine() {
+	defer c.routines.Done()
+
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
@@ -197,7 +715,7 @@ func (c *DNSCache) cleanupRoutine() {
 					next = e.Next()
 					item := e.Value.(*CacheItem)
 
-					if now.After(item.Expires) {
+					if now.After(item.Expires.Add(c.staleMaxAge)) {
 						shard.ll.Remove(e)
 						delete(shard.store, item.Key)
 					}
@@ -213,8 +731,26 @@ func (c *DNSCache) cleanupRoutine() {
 	}
 }
 
+// Background Routines are Started Separately from NewCache,
+// So Cache Built for Failed Reload Never Runs Them
+func (c *DNSCache) Start() {
+	if !c.enabled {
+		return
+	}
+
+	c.routines.Add(1)
+	go c.cleanupRoutine()
+
+	if c.persistFile != "" && c.persistInterval > 0 {
+		c.routines.Add(1)
+		go c.persistRoutine()
+	}
+}
+
+// Stop Routines and Wait for Snapshot Being Saved to Finish
 func (c *DNSCache) Stop() {
 	if c.enabled {
 		close(c.stop)
+		c.routines.Wait()
 	}
 }
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Snapshot File Layout:
+//
+//	Header : Magic (8 Bytes) | Version (uint16)
+//	Record : Key Length (uint16) | Key | ECS Family (uint16) | ECS Scope (uint8) |
+//	         Expires (int64 Unix Nano) | TTL (int64 Nano) |
+//	         Message Length (uint16) | Packed Message
+//	Footer : CRC32 (IEEE) of Header and All Records
+//
+// All Integers are Big Endian
+const (
+	cacheSnapshotMagic   = "DNSPCACH"
+	cacheSnapshotVersion = 2
+)
+
+func (c *DNSCache) persistRoutine() {
+	defer c.routines.Done()
+
+	ticker := time.NewTicker(c.persistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Save(); err != nil {
+				log.Printf("Error Failed to Save DNS Cache Snapshot: %v", err)
+			}
+
+		case <-c.stop:
+			// Stop Routine when Stop Signal Recieved
+			return
+		}
+	}
+}
+
+// Write All Cache Shards to Snapshot File, Written to Uniquely
+// Named Temporary File First Then Renamed to Replace Old Snapshot
+func (c *DNSCache) Save() error {
+	if !c.enabled || c.persistFile == "" {
+		return nil
+	}
+
+	c.persistMu.Lock()
+	defer c.persistMu.Unlock()
+
+	file, err := os.CreateTemp(filepath.Dir(c.persistFile), filepath.Base(c.persistFile)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := file.Name()
+
+	crc := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(file, crc))
+
+	w.WriteString(cacheSnapshotMagic)
+	binary.Write(w, binary.BigEndian, uint16(cacheSnapshotVersion))
+
+	count := 0
+	for _, item := range c.items() {
+		packed, err := item.Msg.Copy().Pack()
+		if err != nil || len(packed) > 0xFFFF || len(item.Key) > 0xFFFF {
+			continue
+		}
+
+		binary.Write(w, binary.BigEndian, uint16(len(item.Key)))
+		w.WriteString(item.Key)
+		binary.Write(w, binary.BigEndian, item.Family)
+		w.WriteByte(item.Scope)
+		binary.Write(w, binary.BigEndian, item.Expires.UnixNano())
+		binary.Write(w, binary.BigEndian, int64(item.TTL))
+		binary.Write(w, binary.BigEndian, uint16(len(packed)))
+		w.Write(packed)
+
+		count++
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = binary.Write(file, binary.BigEndian, crc.Sum32())
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	if err := os.Rename(tmpFile, c.persistFile); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	log.Printf("Saved: DNS Cache Snapshot (File: %s, Entries: %d)", c.persistFile, count)
+
+	return nil
+}
+
+// Read Snapshot File in to Cache, Skipping Entries Past Their Expiry
+// (or Past Max Stale Age). Corrupted File Leaves The Cache Empty
+func (c *DNSCache) Load() error {
+	if !c.enabled || c.persistFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.persistFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	items, err := parseCacheSnapshot(data)
+	if err != nil {
+		return fmt.Errorf("Error Invalid DNS Cache Snapshot %s: %w", c.persistFile, err)
+	}
+
+	now := time.Now()
+
+	count := 0
+	for _, item := range items {
+		if now.After(item.Expires.Add(c.staleMaxAge)) {
+			continue
+		}
+
+		c.add(item)
+		count++
+	}
+
+	log.Printf("Loaded: DNS Cache Snapshot (File: %s, Entries: %d)", c.persistFile, count)
+
+	return nil
+}
+
+func parseCacheSnapshot(data []byte) ([]*CacheItem, error) {
+	headerLen := len(cacheSnapshotMagic) + 2
+	if len(data) < headerLen+4 {
+		return nil, errors.New("File is Too Short")
+	}
+
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[len(data)-4:]) {
+		return nil, errors.New("Checksum Mismatch")
+	}
+
+	if string(body[:len(cacheSnapshotMagic)]) != cacheSnapshotMagic {
+		return nil, errors.New("Unknown File Format")
+	}
+
+	if version := binary.BigEndian.Uint16(body[len(cacheSnapshotMagic):]); version != cacheSnapshotVersion {
+		return nil, fmt.Errorf("Unsupported Version %d", version)
+	}
+
+	var items []*CacheItem
+
+	r := bytes.NewReader(body[headerLen:])
+	for r.Len() > 0 {
+		var keyLen, family, msgLen uint16
+		var scope uint8
+		var expires, ttl int64
+
+		if err := binary.Read(r, binary.BigEndian, &keyLen); err != nil {
+			return nil, err
+		}
+
+		k := make([]byte, keyLen)
+		if _, err := io.ReadFull(r, k); err != nil {
+			return nil, err
+		}
+
+		if err := binary.Read(r, binary.BigEndian, &family); err != nil {
+			return nil, err
+		}
+
+		if err := binary.Read(r, binary.BigEndian, &scope); err != nil {
+			return nil, err
+		}
+
+		if err := binary.Read(r, binary.BigEndian, &expires); err != nil {
+			return nil, err
+		}
+
+		if err := binary.Read(r, binary.BigEndian, &ttl); err != nil {
+			return nil, err
+		}
+
+		if err := binary.Read(r, binary.BigEndian, &msgLen); err != nil {
+			return nil, err
+		}
+
+		packed := make([]byte, msgLen)
+		if _, err := io.ReadFull(r, packed); err != nil {
+			return nil, err
+		}
+
+		msg := new(dns.Msg)
+		if err := msg.Unpack(packed); err != nil {
+			return nil, err
+		}
+
+		items = append(items, &CacheItem{
+			Key:     string(k),
+			Family:  family,
+			Scope:   scope,
+			Msg:     msg,
+			TTL:     time.Duration(ttl),
+			Expires: time.Unix(0, expires),
+		})
+	}
+
+	return items, nil
+}
+
+// Collect Cache Items from Least to Most Recently Used,
+// So Loading Them Back Keeps The LRU Order
+func (c *DNSCache) items() []*CacheItem {
+	var items []*CacheItem
+
+	for _, shard := range c.shards {
+		shard.mu.RLock()
+
+		for e := shard.ll.Back(); e != nil; e = e.Prev() {
+			items = append(items, e.Value.(*CacheItem))
+		}
+
+		shard.mu.RUnlock()
+	}
+
+	return items
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type CircuitBreaker struct {
+	name       string
+	state      int
+	failures   int
+	threshold  int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	backoff    time.Duration
+	openUntil  time.Time
+	mu         sync.Mutex
+}
+
+func NewCircuitBreaker(cfg CircuitBreakerConfig, name string) *CircuitBreaker {
+	if !cfg.Enable {
+		return nil
+	}
+
+	cb := &CircuitBreaker{
+		name:       name,
+		threshold:  cfg.FailureThreshold,
+		minBackoff: time.Duration(cfg.Backoff) * time.Second,
+		maxBackoff: time.Duration(cfg.MaxBackoff) * time.Second,
+	}
+
+	if cb.threshold < 1 {
+		cb.threshold = 1
+	}
+
+	if cb.minBackoff <= 0 {
+		cb.minBackoff = time.Second
+	}
+
+	if cb.maxBackoff < cb.minBackoff {
+		cb.maxBackoff = cb.minBackoff
+	}
+
+	return cb
+}
+
+// Check Whether Upstream Can be Selected Without Claiming Trial
+func (cb *CircuitBreaker) Ready() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		return !time.Now().Before(cb.openUntil)
+	case circuitHalfOpen:
+		return false
+	}
+
+	return true
+}
+
+// Claim Permission to Send Query, Only One Trial
+// Query is Allowed While Circuit is Half-Open
+func (cb *CircuitBreaker) Acquire() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if time.Now().Before(cb.openUntil) {
+			return false
+		}
+
+		cb.state = circuitHalfOpen
+		return true
+
+	case circuitHalfOpen:
+		return false
+	}
+
+	return true
+}
+
+// End Backoff of Open Circuit Early, So Next Query is Sent as Trial,
+// Used when No Upstream in Group is Ready
+func (cb *CircuitBreaker) ForceTrial() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == circuitOpen {
+		cb.openUntil = time.Now()
+	}
+}
+
+// Give Back Trial Permission when Query Outcome is Unknown
+func (cb *CircuitBreaker) Release() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == circuitHalfOpen {
+		cb.state = circuitOpen
+	}
+}
+
+func (cb *CircuitBreaker) Success() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != circuitClosed {
+		log.Printf("Upstream %s Circuit Closed", cb.name)
+	}
+
+	cb.state = circuitClosed
+	cb.failures = 0
+	cb.backoff = 0
+}
+
+func (cb *CircuitBreaker) Failure(err error) {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitHalfOpen:
+		// Trial Query Failed, Open Again with Doubled Backoff
+		cb.backoff *= 2
+		if cb.backoff > cb.maxBackoff {
+			cb.backoff = cb.maxBackoff
+		}
+
+	case circuitClosed:
+		cb.failures++
+		if cb.failures < cb.threshold {
+			return
+		}
+
+		cb.backoff = cb.minBackoff
+
+	default:
+		return
+	}
+
+	cb.state = circuitOpen
+	cb.openUntil = time.Now().Add(cb.backoff)
+
+	log.Printf("Upstream %s Circuit Opened for %v (Failures: %d): %v", cb.name, cb.backoff, cb.failures, err)
+}
+
+func (cb *CircuitBreaker) Open() bool {
+	if cb == nil {
+		return false
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.state != circuitClosed
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Policy Applied to Queries from Matching Clients
+type ClientGroup struct {
+	name       string
+	view       string
+	cidrs      []*net.IPNet
+	listen     []clientListenAddr
+	dohPaths   []string
+	dohTokens  []string
+	upstreams  *UpstreamGroup
+	blocklist  bool
+	safeSearch string
+	edns       *EDNSHandler
+}
+
+// Listener Address, Unspecified IP Matches Any Local Address
+type clientListenAddr struct {
+	ip   net.IP
+	port int
+}
+
+type ClientGroups struct {
+	groups   []*ClientGroup
+	fallback *ClientGroup
+	dohPath  string
+}
+
+func NewClientGroups(cfg ClientsConfig, upstreamCfg UpstreamConfig, ednsCfg EDNSConfig, dohPath string) (*ClientGroups, error) {
+	// Clients Not Matching Any Group Use Global Settings
+	cg := &ClientGroups{
+		fallback: &ClientGroup{
+			name:      "default",
+			blocklist: true,
+			edns:      NewEDNSHandler(ednsCfg),
+		},
+		dohPath: dohPath,
+	}
+
+	if !cfg.Enable {
+		return cg, nil
+	}
+
+	names := make(map[string]bool)
+
+	for _, groupCfg := range cfg.Groups {
+		if groupCfg.Name == "" || groupCfg.Name == cg.fallback.name || names[groupCfg.Name] {
+			return nil, fmt.Errorf("Error Client Group Name '%s' is Empty or Not Unique", groupCfg.Name)
+		}
+
+		names[groupCfg.Name] = true
+
+		group, err := newClientGroup(groupCfg, upstreamCfg, ednsCfg)
+		if err != nil {
+			return nil, fmt.Errorf("Error Client Group '%s': %w", groupCfg.Name, err)
+		}
+
+		cg.groups = append(cg.groups, group)
+	}
+
+	return cg, nil
+}
+
+func newClientGroup(cfg ClientGroupConfig, upstreamCfg UpstreamConfig, ednsCfg EDNSConfig) (*ClientGroup, error) {
+	group := &ClientGroup{
+		name:       cfg.Name,
+		dohPaths:   cfg.DoHPaths,
+		dohTokens:  cfg.DoHTokens,
+		blocklist:  true,
+		safeSearch: strings.ToLower(cfg.SafeSearch),
+		edns:       NewEDNSHandler(ednsCfg),
+	}
+
+	for _, cidr := range cfg.CIDRs {
+		// Bare Address is Treated as Single Host Network
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		group.cidrs = append(group.cidrs, ipNet)
+	}
+
+	for _, addr := range cfg.Listen {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("Error Invalid Listen Port '%s'", addr)
+		}
+
+		listenAddr := clientListenAddr{port: port}
+		if host != "" {
+			if listenAddr.ip = net.ParseIP(host); listenAddr.ip == nil {
+				return nil, fmt.Errorf("Error Invalid Listen Address '%s'", addr)
+			}
+		}
+
+		group.listen = append(group.listen, listenAddr)
+	}
+
+	if len(cfg.Upstreams) > 0 {
+		// Group Upstreams Get Their Own View of The Cache
+		groupCfg := upstreamCfg
+
+		if cfg.Strategy != "" {
+			groupCfg.Strategy = cfg.Strategy
+		}
+
+		if cfg.Parallel > 0 {
+			groupCfg.Parallel = cfg.Parallel
+		}
+
+		var upstreams []*Upstream
+		for _, raw := range cfg.Upstreams {
+			u, err := NewUpstream(strings.TrimSpace(raw), groupCfg)
+			if err != nil {
+				return nil, err
+			}
+
+			upstreams = append(upstreams, u)
+		}
+
+		upstreamGroup, err := NewUpstreamGroup(upstreams, groupCfg.Strategy, groupCfg.Parallel)
+		if err != nil {
+			return nil, err
+		}
+
+		group.upstreams = upstreamGroup
+		group.view = cfg.Name
+	}
+
+	if cfg.Blocklist != nil {
+		group.blocklist = *cfg.Blocklist
+	}
+
+	switch group.safeSearch {
+	case "", "off":
+		group.safeSearch = ""
+	case "moderate", "strict":
+	default:
+		return nil, fmt.Errorf("Error Invalid Safe Search Policy '%s'", cfg.SafeSearch)
+	}
+
+	if cfg.ECS != nil {
+		ecsCfg := ednsCfg
+		ecsCfg.Enable = *cfg.ECS
+
+		group.edns = NewEDNSHandler(ecsCfg)
+	}
+
+	return group, nil
+}
+
+func (g *ClientGroup) String() string {
+	return g.name
+}
+
+// Find First Group Matching Client Address, Listener Address or
+// DoH Request Path, Clients Not Matching Any Group Get Default Group
+func (cg *ClientGroups) Match(w dns.ResponseWriter) *ClientGroup {
+	if len(cg.groups) == 0 {
+		return cg.fallback
+	}
+
+	remoteIP, _ := addrIPPort(w.RemoteAddr())
+	localIP, localPort := addrIPPort(w.LocalAddr())
+
+	// Token is Only Set when Path is Main Path Followed by Token,
+	// So Path of One Group Never Matches Token of Another
+	var path, token string
+	if mw, ok := w.(*msgResponseWriter); ok && mw.path != "" {
+		path = mw.path
+		if t, found := strings.CutPrefix(path, strings.TrimSuffix(cg.dohPath, "/")+"/"); found {
+			token = t
+		}
+	}
+
+	for _, g := range cg.groups {
+		for _, ipNet := range g.cidrs {
+			if remoteIP != nil && ipNet.Contains(remoteIP) {
+				return g
+			}
+		}
+
+		for _, addr := range g.listen {
+			if addr.port == localPort && (addr.ip == nil || addr.ip.IsUnspecified() || addr.ip.Equal(localIP)) {
+				return g
+			}
+		}
+
+		if path == "" {
+			continue
+		}
+
+		for _, dohPath := range g.dohPaths {
+			if dohPath == path {
+				return g
+			}
+		}
+
+		for _, dohToken := range g.dohTokens {
+			if token != "" && dohToken == token {
+				return g
+			}
+		}
+	}
+
+	return cg.fallback
+}
+
+// Find Group by Cache View, Used by Background Refresh of Cache Entries
+func (cg *ClientGroups) Group(view string) *ClientGroup {
+	for _, g := range cg.groups {
+		if view != "" && g.view == view {
+			return g
+		}
+	}
+
+	return cg.fallback
+}
+
+// Check if DoH Request Path is Served, Either Main Path,
+// Group Path or Main Path Followed by Group Token
+func (cg *ClientGroups) ServesDoHPath(path string) bool {
+	if path == cg.dohPath {
+		return true
+	}
+
+	token, _ := strings.CutPrefix(path, strings.TrimSuffix(cg.dohPath, "/")+"/")
+
+	for _, g := range cg.groups {
+		for _, dohPath := range g.dohPaths {
+			if dohPath == path {
+				return true
+			}
+		}
+
+		for _, dohToken := range g.dohTokens {
+			if token != "" && token != path && dohToken == token {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Upstream Groups of Client Groups Overriding Upstreams
+func (cg *ClientGroups) Groups() map[string]*UpstreamGroup {
+	groups := make(map[string]*UpstreamGroup)
+	for _, g := range cg.groups {
+		if g.upstreams != nil {
+			groups[g.name] = g.upstreams
+		}
+	}
+
+	return groups
+}
+
+// Return Cache Views of Client Groups That were Added, Removed or Changed
+func diffClientGroups(oldCfg ClientsConfig, newCfg ClientsConfig) []string {
+	oldGroups := clientGroupsByName(oldCfg)
+	newGroups := clientGroupsByName(newCfg)
+
+	var changed []string
+
+	for name, group := range oldGroups {
+		if newGroup, found := newGroups[name]; !found || !reflect.DeepEqual(group, newGroup) {
+			changed = append(changed, name)
+		}
+	}
+
+	for name := range newGroups {
+		if _, found := oldGroups[name]; !found {
+			changed = append(changed, name)
+		}
+	}
+
+	return changed
+}
+
+func clientGroupsByName(cfg ClientsConfig) map[string]ClientGroupConfig {
+	groups := make(map[string]ClientGroupConfig)
+	if !cfg.Enable {
+		return groups
+	}
+
+	for _, group := range cfg.Groups {
+		groups[group.Name] = group
+	}
+
+	return groups
+}
+
+func addrIPPort(addr net.Addr) (net.IP, int) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	}
+
+	return nil, 0
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClientGroupsMatchDoH(t *testing.T) {
+	cg, err := NewClientGroups(ClientsConfig{
+		Enable: true,
+		Groups: []ClientGroupConfig{
+			{Name: "token", DoHTokens: []string{"guest-query"}},
+			{Name: "path", DoHPaths: []string{"guest-query", "/guest-query"}},
+		},
+	}, UpstreamConfig{}, EDNSConfig{}, "/dns-query")
+	if err != nil {
+		t.Fatalf("NewClientGroups: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/dns-query", "default"},
+		{"/dns-query/guest-query", "token"},
+		{"guest-query", "path"},
+		{"/guest-query", "path"},
+		{"/other", "default"},
+	}
+
+	for _, tt := range tests {
+		w := &msgResponseWriter{
+			localAddr:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443},
+			remoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 50000},
+			path:       tt.path,
+		}
+
+		if got := cg.Match(w).String(); got != tt.want {
+			t.Errorf("Match(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
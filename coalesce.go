@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+type inflightRequest struct {
+	done    chan struct{}
+	resp    *dns.Msg
+	err     error
+	waiters int
+}
+
+type RequestCoalescer struct {
+	inflight  map[string]*inflightRequest
+	coalesced atomic.Uint64
+	mu        sync.Mutex
+}
+
+func NewRequestCoalescer() *RequestCoalescer {
+	return &RequestCoalescer{
+		inflight: make(map[string]*inflightRequest),
+	}
+}
+
+// Run Resolve Once for All Concurrent Callers with The Same Key,
+// Callers Waiting on Another Request Get Their Own Copy of Response
+func (rc *RequestCoalescer) Do(key string, resolve func() (*dns.Msg, error)) (*dns.Msg, error) {
+	rc.mu.Lock()
+
+	if req, found := rc.inflight[key]; found {
+		req.waiters++
+		rc.mu.Unlock()
+
+		rc.coalesced.Add(1)
+		<-req.done
+
+		if req.resp == nil {
+			return nil, req.err
+		}
+
+		return req.resp.Copy(), req.err
+	}
+
+	req := &inflightRequest{
+		done: make(chan struct{}),
+	}
+
+	rc.inflight[key] = req
+	rc.mu.Unlock()
+
+	req.resp, req.err = resolve()
+
+	rc.mu.Lock()
+	delete(rc.inflight, key)
+	waiters := req.waiters
+	rc.mu.Unlock()
+
+	close(req.done)
+
+	// Response is Shared with Waiters, Keep The Original Unmodified
+	if waiters > 0 && req.resp != nil {
+		return req.resp.Copy(), req.err
+	}
+
+	return req.resp, req.err
+}
+
+func (rc *RequestCoalescer) Coalesced() uint64 {
+	return rc.coalesced.Load()
+}
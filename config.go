@@ -12,28 +12,61 @@ type Config struct {
 	Upstream      UpstreamConfig      `yaml:"upstream"`
 	Cache         CacheConfig         `yaml:"cache"`
 	BogusNXDomain BogusNXDomainConfig `yaml:"bogus-nxdomain"`
+	Blocklist     BlocklistConfig     `yaml:"blocklist"`
 	EDNS          EDNSConfig          `yaml:"edns"`
 	Local         LocalConfig         `yaml:"local"`
 	Forwarder     ForwarderConfig     `yaml:"forwarder"`
+	Clients       ClientsConfig       `yaml:"clients"`
+	HealthCheck   HealthCheckConfig   `yaml:"health_check"`
+	Metrics       MetricsConfig       `yaml:"metrics"`
 }
 
 type ServerConfig struct {
-	Listen   []string `yaml:"listen"`
-	Compress bool     `yaml:"compress"`
+	Listen   []string        `yaml:"listen"`
+	Compress bool            `yaml:"compress"`
+	TLS      ServerTLSConfig `yaml:"tls"`
+	DoH      ServerDoHConfig `yaml:"doh"`
+	DoQ      ServerDoQConfig `yaml:"doq"`
+}
+
+type ServerTLSConfig struct {
+	Enable       bool     `yaml:"enable"`
+	Listen       []string `yaml:"listen"`
+	CertFile     string   `yaml:"cert_file"`
+	KeyFile      string   `yaml:"key_file"`
+	ClientCAFile string   `yaml:"client_ca_file"`
+	MinVersion   string   `yaml:"min_version"`
+}
+
+type ServerDoHConfig struct {
+	Enable bool     `yaml:"enable"`
+	Listen []string `yaml:"listen"`
+	Path   string   `yaml:"path"`
+	HTTP3  bool     `yaml:"http3"`
+}
+
+type ServerDoQConfig struct {
+	Enable      bool     `yaml:"enable"`
+	Listen      []string `yaml:"listen"`
+	IdleTimeout int      `yaml:"idle_timeout"`
+	MaxStreams  int      `yaml:"max_streams"`
 }
 
 type UpstreamConfig struct {
-	Mode          string    `yaml:"mode"`
-	Timeout       int       `yaml:"timeout"`
-	KeepAlive     int       `yaml:"keep_alive"`
-	BufferSize    int       `yaml:"buffer_size"`
-	PoolSize      int       `yaml:"pool_size"`
-	MaxAttempts   int       `yaml:"max_attempts"`
-	DisableIPv6   bool      `yaml:"disable_ipv6"`
-	SkipTLSVerify bool      `yaml:"skip_tls_verify"`
-	Domain        string    `yaml:"domain"`
-	Addresses     []string  `yaml:"addresses"`
-	DoH           DoHConfig `yaml:"doh"`
+	Mode           string               `yaml:"mode"`
+	Strategy       string               `yaml:"strategy"`
+	Parallel       int                  `yaml:"parallel"`
+	Timeout        int                  `yaml:"timeout"`
+	KeepAlive      int                  `yaml:"keep_alive"`
+	BufferSize     int                  `yaml:"buffer_size"`
+	PoolSize       int                  `yaml:"pool_size"`
+	MaxAttempts    int                  `yaml:"max_attempts"`
+	DisableIPv6    bool                 `yaml:"disable_ipv6"`
+	SkipTLSVerify  bool                 `yaml:"skip_tls_verify"`
+	Domain         string               `yaml:"domain"`
+	Addresses      []string             `yaml:"addresses"`
+	DoH            DoHConfig            `yaml:"doh"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
 }
 
 type DoHConfig struct {
@@ -41,20 +74,86 @@ type DoHConfig struct {
 	Idle      IdleConfig `yaml:"idle"`
 }
 
+type CircuitBreakerConfig struct {
+	Enable           bool `yaml:"enable"`
+	FailureThreshold int  `yaml:"failure_threshold"`
+	Backoff          int  `yaml:"backoff"`
+	MaxBackoff       int  `yaml:"max_backoff"`
+}
+
 type IdleConfig struct {
 	MaxConnection        int `yaml:"max_conn"`
 	MaxConnectionPerHost int `yaml:"max_per_host"`
 }
 type CacheConfig struct {
-	Size   int `yaml:"size"`
-	Shards int `yaml:"shards"`
-	MinTTL int `yaml:"min_ttl"`
-	NegTTL int `yaml:"neg_ttl"`
+	Size        int                `yaml:"size"`
+	Shards      int                `yaml:"shards"`
+	MinTTL      int                `yaml:"min_ttl"`
+	MaxTTL      int                `yaml:"max_ttl"`
+	NegTTL      int                `yaml:"neg_ttl"`
+	MaxNegTTL   int                `yaml:"max_neg_ttl"`
+	ServFailTTL int                `yaml:"servfail_ttl"`
+	ServeStale  ServeStaleConfig   `yaml:"serve_stale"`
+	Prefetch    PrefetchConfig     `yaml:"prefetch"`
+	Rules       []CacheRule        `yaml:"rules"`
+	Persist     CachePersistConfig `yaml:"persist"`
+}
+
+type CachePersistConfig struct {
+	Enable   bool   `yaml:"enable"`
+	File     string `yaml:"file"`
+	Interval int    `yaml:"interval"`
+}
+
+type CacheRule struct {
+	Domain  string `yaml:"domain"`
+	MinTTL  int    `yaml:"min_ttl"`
+	MaxTTL  int    `yaml:"max_ttl"`
+	TTL     int    `yaml:"ttl"`
+	NoCache bool   `yaml:"no_cache"`
+}
+
+type ServeStaleConfig struct {
+	Enable        bool `yaml:"enable"`
+	MaxAge        int  `yaml:"max_age"`
+	ClientTimeout int  `yaml:"client_timeout"`
+}
+
+type PrefetchConfig struct {
+	Enable      bool `yaml:"enable"`
+	Threshold   int  `yaml:"threshold"`
+	MinHits     int  `yaml:"min_hits"`
+	Concurrency int  `yaml:"concurrency"`
 }
 
 type BogusNXDomainConfig struct {
-	Enable bool     `yaml:"enable"`
-	IPs    []string `yaml:"ips"`
+	Enable   bool                `yaml:"enable"`
+	IPs      []string            `yaml:"ips"`
+	Response BlockResponseConfig `yaml:"response"`
+}
+
+type BlocklistConfig struct {
+	Enable          bool                    `yaml:"enable"`
+	Files           []string                `yaml:"files"`
+	Rules           []string                `yaml:"rules"`
+	Subscriptions   []BlocklistSubscription `yaml:"subscriptions"`
+	RefreshInterval int                     `yaml:"refresh_interval"`
+	CacheDir        string                  `yaml:"cache_dir"`
+	Response        BlockResponseConfig     `yaml:"response"`
+}
+
+type BlocklistSubscription struct {
+	URL             string               `yaml:"url"`
+	RefreshInterval int                  `yaml:"refresh_interval"`
+	Response        *BlockResponseConfig `yaml:"response"`
+}
+
+type BlockResponseConfig struct {
+	Mode          string `yaml:"mode"`
+	IPv4          string `yaml:"ipv4"`
+	IPv6          string `yaml:"ipv6"`
+	TTL           int    `yaml:"ttl"`
+	ExtendedError string `yaml:"extended_error"`
 }
 
 type EDNSConfig struct {
@@ -83,8 +182,48 @@ type ForwarderConfig struct {
 }
 
 type ForwarderRule struct {
-	Domain    string   `yaml:"domain"`
-	Upstreams []string `yaml:"upstreams"`
+	Domain        string   `yaml:"domain"`
+	Mode          string   `yaml:"mode"`
+	Strategy      string   `yaml:"strategy"`
+	Parallel      int      `yaml:"parallel"`
+	ServerName    string   `yaml:"server_name"`
+	SkipTLSVerify *bool    `yaml:"skip_tls_verify"`
+	Timeout       int      `yaml:"timeout"`
+	Upstreams     []string `yaml:"upstreams"`
+}
+
+type ClientsConfig struct {
+	Enable bool                `yaml:"enable"`
+	Groups []ClientGroupConfig `yaml:"groups"`
+}
+
+type ClientGroupConfig struct {
+	Name       string   `yaml:"name"`
+	CIDRs      []string `yaml:"cidrs"`
+	Listen     []string `yaml:"listen"`
+	DoHPaths   []string `yaml:"doh_paths"`
+	DoHTokens  []string `yaml:"doh_tokens"`
+	Upstreams  []string `yaml:"upstreams"`
+	Strategy   string   `yaml:"strategy"`
+	Parallel   int      `yaml:"parallel"`
+	Blocklist  *bool    `yaml:"blocklist"`
+	SafeSearch string   `yaml:"safe_search"`
+	ECS        *bool    `yaml:"ecs"`
+}
+
+type HealthCheckConfig struct {
+	Enable           bool   `yaml:"enable"`
+	Interval         int    `yaml:"interval"`
+	Query            string `yaml:"query"`
+	QueryType        string `yaml:"query_type"`
+	FailThreshold    int    `yaml:"fail_threshold"`
+	SuccessThreshold int    `yaml:"success_threshold"`
+}
+
+type MetricsConfig struct {
+	Enable bool   `yaml:"enable"`
+	Listen string `yaml:"listen"`
+	Path   string `yaml:"path"`
 }
 
 func LoadConfig(filename string) (*Config, error) {
@@ -99,6 +238,20 @@ func LoadConfig(filename string) (*Config, error) {
 	config.Server.Listen = []string{"0.0.0.0:5353"}
 	config.Server.Compress = true
 
+	config.Server.TLS.Enable = false
+	config.Server.TLS.Listen = []string{"0.0.0.0:853"}
+	config.Server.TLS.MinVersion = "1.2"
+
+	config.Server.DoH.Enable = false
+	config.Server.DoH.Listen = []string{"0.0.0.0:443"}
+	config.Server.DoH.Path = "/dns-query"
+	config.Server.DoH.HTTP3 = true
+
+	config.Server.DoQ.Enable = false
+	config.Server.DoQ.Listen = []string{"0.0.0.0:853"}
+	config.Server.DoQ.IdleTimeout = 30
+	config.Server.DoQ.MaxStreams = 100
+
 	config.Upstream.Timeout = 10
 	config.Upstream.KeepAlive = 60
 	config.Upstream.BufferSize = 4096
@@ -107,17 +260,50 @@ func LoadConfig(filename string) (*Config, error) {
 	config.Upstream.DisableIPv6 = false
 	config.Upstream.SkipTLSVerify = true
 	config.Upstream.Mode = "udp"
+	config.Upstream.Strategy = "failover"
+	config.Upstream.Parallel = 0
 
 	config.Upstream.DoH.QueryPath = "/dns-query"
 	config.Upstream.DoH.Idle.MaxConnection = 100
 	config.Upstream.DoH.Idle.MaxConnectionPerHost = 20
 
+	config.Upstream.CircuitBreaker.Enable = false
+	config.Upstream.CircuitBreaker.FailureThreshold = 5
+	config.Upstream.CircuitBreaker.Backoff = 5
+	config.Upstream.CircuitBreaker.MaxBackoff = 300
+
 	config.Cache.Size = 10000
 	config.Cache.Shards = 256
 	config.Cache.MinTTL = 60
+	config.Cache.MaxTTL = 0
 	config.Cache.NegTTL = 1
+	config.Cache.MaxNegTTL = 3600
+	config.Cache.ServFailTTL = 1
+
+	config.Cache.ServeStale.Enable = false
+	config.Cache.ServeStale.MaxAge = 86400
+	config.Cache.ServeStale.ClientTimeout = 1800
+
+	config.Cache.Prefetch.Enable = false
+	config.Cache.Prefetch.Threshold = 10
+	config.Cache.Prefetch.MinHits = 3
+	config.Cache.Prefetch.Concurrency = 10
+
+	config.Cache.Persist.Enable = false
+	config.Cache.Persist.File = "./dns-proxy.cache"
+	config.Cache.Persist.Interval = 300
 
 	config.BogusNXDomain.Enable = false
+	config.BogusNXDomain.Response.Mode = "null"
+	config.BogusNXDomain.Response.TTL = 300
+	config.BogusNXDomain.Response.ExtendedError = "filtered"
+
+	config.Blocklist.Enable = false
+	config.Blocklist.RefreshInterval = 86400
+	config.Blocklist.CacheDir = "./blocklists"
+	config.Blocklist.Response.Mode = "nxdomain"
+	config.Blocklist.Response.TTL = 300
+	config.Blocklist.Response.ExtendedError = "blocked"
 
 	config.EDNS.Enable = false
 	config.EDNS.IPv4Mask = 24
@@ -128,6 +314,19 @@ func LoadConfig(filename string) (*Config, error) {
 
 	config.Forwarder.Enable = false
 
+	config.Clients.Enable = false
+
+	config.HealthCheck.Enable = false
+	config.HealthCheck.Interval = 10
+	config.HealthCheck.Query = "."
+	config.HealthCheck.QueryType = "NS"
+	config.HealthCheck.FailThreshold = 3
+	config.HealthCheck.SuccessThreshold = 1
+
+	config.Metrics.Enable = false
+	config.Metrics.Listen = "127.0.0.1:9153"
+	config.Metrics.Path = "/metrics"
+
 	err = yaml.Unmarshal(data, config)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigExample(t *testing.T) {
+	cfg, err := LoadConfig("dns-proxy.yaml.example")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	if len(cfg.Blocklist.Rules) == 0 || cfg.Blocklist.Rules[0] != "||doubleclick.net^" {
+		t.Errorf("Blocklist.Rules = %q, want first rule ||doubleclick.net^", cfg.Blocklist.Rules)
+	}
+
+	for _, raw := range cfg.Upstream.Addresses {
+		if _, err := parseUpstream(raw, cfg.Upstream); err != nil {
+			t.Errorf("upstream %q: %v", raw, err)
+		}
+	}
+
+	cfg.Clients.Enable = true
+
+	groups, err := NewClientGroups(cfg.Clients, cfg.Upstream, cfg.EDNS, cfg.Server.DoH.Path)
+	if err != nil {
+		t.Fatalf("NewClientGroups: %v", err)
+	}
+
+	servers := groups.Group("servers")
+	if servers.upstreams == nil {
+		t.Fatal("servers group has no upstreams")
+	}
+
+	for _, u := range servers.upstreams.upstreams {
+		if u.Proto == "dot" && strings.HasSuffix(u.Address, ":53") {
+			t.Errorf("servers group upstream %s uses DoT on plain DNS port", u)
+		}
+	}
+}
@@ -3,77 +3,124 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/miekg/dns"
+	quic "github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 )
 
-func forwardDoH(m *dns.Msg, urls []string) (*dns.Msg, error) {
-	var errLast error
-
-	packed, _ := m.Pack()
-
-	for _, url := range urls {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Upstream.Timeout)*time.Second)
-
-		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(packed))
-		if err != nil {
-			cancel()
-			return nil, err
-		}
+// Timeout is Taken from Upstream Itself, as It May Override Global Timeout
+func newDoHClient(cfg UpstreamConfig, serverName string, skipTLSVerify bool, dialAddr string, timeout time.Duration) *http.Client {
+	dohDialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: time.Duration(cfg.KeepAlive) * time.Second,
+		Control:   setSocketOptions,
+	}
 
-		req.Header.Set("Content-Type", "application/dns-message")
-		req.Header.Set("Accept", "application/dns-message")
+	dohTransportH2 := &http.Transport{
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			// Dial Pinned Address when Configured
+			if dialAddr != "" {
+				addr = dialAddr
+			}
+
+			conn, err := dohDialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			if tcpConn, ok := conn.(*net.TCPConn); ok {
+				setTCPOptions(tcpConn)
+			}
+
+			return conn, nil
+		},
+		MaxIdleConns:          cfg.DoH.Idle.MaxConnection,
+		MaxIdleConnsPerHost:   cfg.DoH.Idle.MaxConnectionPerHost,
+		IdleConnTimeout:       time.Duration(cfg.KeepAlive) * time.Second,
+		ResponseHeaderTimeout: timeout,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: skipTLSVerify,
+			ClientSessionCache: tls.NewLRUClientSessionCache(cfg.PoolSize),
+		},
+	}
 
-		resp, err := dohClient.Do(req)
-		if err != nil {
-			errLast = err
-			cancel()
+	dohTransportH3 := &http3.Transport{
+		EnableDatagrams: true,
+		QUICConfig: &quic.Config{
+			KeepAlivePeriod: time.Duration(cfg.KeepAlive) * time.Second,
+			MaxIdleTimeout:  timeout,
+		},
+		TLSClientConfig: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: skipTLSVerify,
+			ClientSessionCache: tls.NewLRUClientSessionCache(cfg.PoolSize),
+		},
+	}
 
-			continue
+	if dialAddr != "" {
+		dohTransportH3.Dial = func(ctx context.Context, addr string, tlsCfg *tls.Config, quicCfg *quic.Config) (*quic.Conn, error) {
+			return quic.DialAddrEarly(ctx, dialAddr, tlsCfg, quicCfg)
 		}
+	}
 
-		if resp.StatusCode != 200 {
-			resp.Body.Close()
-			errLast = fmt.Errorf("Error DoH Upstream %s Returned %d", url, resp.StatusCode)
-			cancel()
+	return &http.Client{
+		Transport: &hybridRoundTripper{
+			H2Transport: dohTransportH2,
+			H3Transport: dohTransportH3,
+		},
+		Timeout: timeout,
+	}
+}
 
-			continue
-		}
+func forwardDoH(ctx context.Context, m *dns.Msg, u *Upstream) (*dns.Msg, error) {
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
 
-		bufPtr := bufPool.Get().(*[]byte)
+	ctx, cancel := context.WithTimeout(ctx, u.Timeout)
+	defer cancel()
 
-		buf := (*bufPtr)[:0]
-		buffer := bytes.NewBuffer(buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", u.URL, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
 
-		_, err = buffer.ReadFrom(resp.Body)
-		resp.Body.Close()
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
 
-		if err != nil {
-			// Return Buffer to Pool when Error Occurred
-			bufPool.Put(bufPtr)
-			errLast = err
-			cancel()
+	resp, err := u.dohClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("[DOH] Error Failed to Dial DNS Upstream %s: %w", u.URL, err)
+	}
+	defer resp.Body.Close()
 
-			continue
-		}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("[DOH] Error DoH Upstream %s Returned %d", u.URL, resp.StatusCode)
+	}
 
-		msg := new(dns.Msg)
-		err = msg.Unpack(buffer.Bytes())
+	bufPtr := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bufPtr)
 
-		// Return Buffer to Pool
-		bufPool.Put(bufPtr)
-		cancel()
+	buf := (*bufPtr)[:0]
+	buffer := bytes.NewBuffer(buf)
 
-		if err != nil {
-			errLast = err
-			continue
-		}
+	if _, err := buffer.ReadFrom(resp.Body); err != nil {
+		return nil, fmt.Errorf("[DOH] Error Failed to Read: %w", err)
+	}
 
-		return msg, nil
+	msg := new(dns.Msg)
+	if err := msg.Unpack(buffer.Bytes()); err != nil {
+		return nil, fmt.Errorf("[DOH] Error Failed to Unpack: %w", err)
 	}
 
-	return nil, fmt.Errorf("[DOH] Error Failed to Dial DNS Upstreams: %v", errLast)
+	return msg, nil
 }
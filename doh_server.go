@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	quic "github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+const dohMaxMessageSize = 65535
+
+func startDoHListener(addr string) {
+	lc := net.ListenConfig{
+		Control: setSocketOptions,
+	}
+
+	var h3Server *http3.Server
+
+	// Client Groups May Use Their Own Paths, Which Can Change on Reload
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+		configLock.RLock()
+		served := dnsClients.ServesDoHPath(req.URL.Path)
+		configLock.RUnlock()
+
+		if !served {
+			http.NotFound(rw, req)
+			return
+		}
+
+		// Advertise HTTP/3 Endpoint to HTTP/2 Clients
+		if h3Server != nil && req.ProtoMajor < 3 {
+			h3Server.SetQUICHeaders(rw.Header())
+		}
+
+		handleDoHRequest(rw, req)
+	})
+
+	if config.Server.DoH.HTTP3 {
+		pc, err := lc.ListenPacket(context.Background(), "udp", addr)
+		if err != nil {
+			log.Fatalf("Failed to Listen on 'DOH/H3': %v", err)
+		}
+
+		h3Server = &http3.Server{
+			Handler:   mux,
+			TLSConfig: http3.ConfigureTLSConfig(listenerTLSConfig()),
+			QUICConfig: &quic.Config{
+				MaxIdleTimeout: time.Duration(config.Upstream.KeepAlive) * time.Second,
+			},
+		}
+
+		go func() {
+			if err := h3Server.Serve(pc); err != nil {
+				log.Fatalf("Failed to Start 'DOH/H3' Listener: %s", err.Error())
+			}
+		}()
+	}
+
+	l, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to Listen on 'DOH': %v", err)
+	}
+
+	h2Server := &http.Server{
+		Handler:     mux,
+		TLSConfig:   listenerTLSConfig("h2", "http/1.1"),
+		IdleTimeout: time.Duration(config.Upstream.KeepAlive) * time.Second,
+	}
+
+	if err := h2Server.ServeTLS(l, "", ""); err != nil {
+		log.Fatalf("Failed to Start 'DOH' Listener: %s", err.Error())
+	}
+}
+
+func handleDoHRequest(rw http.ResponseWriter, req *http.Request) {
+	var packed []byte
+	var err error
+
+	switch req.Method {
+	case http.MethodGet:
+		param := req.URL.Query().Get("dns")
+		if param == "" {
+			http.Error(rw, "Missing DNS Query Parameter", http.StatusBadRequest)
+			return
+		}
+
+		// Accept Padded Base64URL for Lenient Clients
+		packed, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+
+	case http.MethodPost:
+		if req.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(rw, "Unsupported Content Type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		packed, err = io.ReadAll(io.LimitReader(req.Body, dohMaxMessageSize))
+
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(rw, "Invalid DNS Query", http.StatusBadRequest)
+		return
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(packed); err != nil || len(msg.Question) == 0 {
+		http.Error(rw, "Invalid DNS Query", http.StatusBadRequest)
+		return
+	}
+
+	w := &msgResponseWriter{
+		remoteAddr: parseHTTPRemoteAddr(req.RemoteAddr),
+		path:       req.URL.Path,
+	}
+
+	if localAddr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		w.localAddr = localAddr
+	}
+
+	handleRequest(w, msg)
+
+	if w.msg == nil {
+		http.Error(rw, "No DNS Response", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := w.msg.Pack()
+	if err != nil {
+		http.Error(rw, "Invalid DNS Response", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/dns-message")
+	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", minAnswerTTL(w.msg)))
+
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(resp)
+}
+
+func parseHTTPRemoteAddr(remoteAddr string) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", remoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+
+	return addr
+}
+
+func minAnswerTTL(m *dns.Msg) uint32 {
+	rrs := m.Answer
+	if len(rrs) == 0 {
+		// Use Authority Section for Negative Response
+		rrs = m.Ns
+	}
+
+	minFound := uint32(0)
+	for i, rr := range rrs {
+		if i == 0 || rr.Header().Ttl < minFound {
+			minFound = rr.Header().Ttl
+		}
+	}
+
+	return minFound
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/miekg/dns"
+	quic "github.com/quic-go/quic-go"
+)
+
+func forwardDoQ(ctx context.Context, m *dns.Msg, u *Upstream) (*dns.Msg, error) {
+	for {
+		conn, reused, err := u.quicPool.Get()
+		if err != nil {
+			return nil, fmt.Errorf("[DOQ] Error Failed to Dial DNS Upstream %s: %w", u.Address, err)
+		}
+
+		resp, err := exchangeDoQ(ctx, conn, m, u.Timeout)
+		if err != nil {
+			// Only Drop Connection when QUIC Connection Itself is Broken
+			// Other In-Flight Streams Might Still Use It
+			if ctx.Err() == nil && isQUICConnError(err) {
+				u.quicPool.Remove(conn)
+
+				if reused {
+					continue
+				}
+			}
+
+			return nil, err
+		}
+
+		return resp, nil
+	}
+}
+
+func exchangeDoQ(ctx context.Context, conn *quic.Conn, m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("[DOQ] Error Failed to Open Stream: %w", err)
+	}
+
+	deadline, _ := ctx.Deadline()
+	stream.SetDeadline(deadline)
+
+	// Interrupt Pending Read/Write when Context is Cancelled
+	stop := context.AfterFunc(ctx, func() {
+		stream.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	// DNS Message ID Must be 0 on DoQ (RFC 9250)
+	q := m.Copy()
+	q.Id = 0
+
+	if err := writeDoQMsg(stream, q); err != nil {
+		stream.CancelRead(0)
+		return nil, fmt.Errorf("[DOQ] Error Failed to Write: %w", err)
+	}
+
+	// Close Send Direction to Signal End of Query
+	stream.Close()
+
+	resp, err := readDoQMsg(stream)
+	if err != nil {
+		stream.CancelRead(0)
+		return nil, fmt.Errorf("[DOQ] Error Failed to Read: %w", err)
+	}
+
+	resp.Id = m.Id
+
+	return resp, nil
+}
+
+func writeDoQMsg(w io.Writer, m *dns.Msg) error {
+	packed, err := m.Pack()
+	if err != nil {
+		return err
+	}
+
+	// Prefix Message with 2-Byte Length
+	buf := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	copy(buf[2:], packed)
+
+	_, err = w.Write(buf)
+	return err
+}
+
+func readDoQMsg(r io.Reader) (*dns.Msg, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(buf); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func isQUICConnError(err error) bool {
+	var idleErr *quic.IdleTimeoutError
+	var resetErr *quic.StatelessResetError
+	var appErr *quic.ApplicationError
+	var transportErr *quic.TransportError
+
+	if errors.As(err, &idleErr) || errors.As(err, &resetErr) || errors.As(err, &appErr) || errors.As(err, &transportErr) {
+		return true
+	}
+
+	return errors.Is(err, quic.Err0RTTRejected)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+const (
+	doqNoError       = 0x0
+	doqInternalError = 0x1
+	doqProtocolError = 0x2
+)
+
+// Settings are Copied When Listener Starts, Streams are Served
+// Without Reading Global Config That May be Swapped by Reload
+type doqServer struct {
+	idleTimeout time.Duration
+	maxStreams  int64
+}
+
+func startDoQListener(addr string, cfg ServerDoQConfig) {
+	srv := &doqServer{
+		idleTimeout: time.Duration(cfg.IdleTimeout) * time.Second,
+		maxStreams:  int64(cfg.MaxStreams),
+	}
+
+	lc := net.ListenConfig{
+		Control: setSocketOptions,
+	}
+
+	pc, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		log.Fatalf("Failed to Listen on 'DOQ': %v", err)
+	}
+
+	ln, err := quic.Listen(pc, listenerTLSConfig("doq"), &quic.Config{
+		MaxIdleTimeout:        srv.idleTimeout,
+		MaxIncomingStreams:    srv.maxStreams,
+		MaxIncomingUniStreams: -1,
+		Allow0RTT:             true,
+	})
+	if err != nil {
+		log.Fatalf("Failed to Start 'DOQ' Listener: %s", err.Error())
+	}
+
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			// Stop Accepting when Listener is Closed
+			if errors.Is(err, quic.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Printf("Error Failed to Accept DoQ Connection: %v", err)
+			continue
+		}
+
+		go srv.handleConn(conn)
+	}
+}
+
+func (srv *doqServer) handleConn(conn *quic.Conn) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			// Connection Closed or Idle Timeout Reached
+			return
+		}
+
+		go srv.handleStream(conn, stream)
+	}
+}
+
+func (srv *doqServer) handleStream(conn *quic.Conn, stream *quic.Stream) {
+	// Zero or Negative Idle Timeout Means No Stream Deadline
+	idleTimeout := srv.idleTimeout
+	if idleTimeout > 0 {
+		stream.SetReadDeadline(time.Now().Add(idleTimeout))
+	}
+
+	msg, err := readDoQMsg(stream)
+	if err != nil || len(msg.Question) == 0 {
+		stream.CancelRead(doqProtocolError)
+		stream.CancelWrite(doqProtocolError)
+
+		return
+	}
+
+	// DNS Message ID Must be 0 on DoQ (RFC 9250)
+	if msg.Id != 0 {
+		conn.CloseWithError(doqProtocolError, "DNS Message ID Must be 0")
+		return
+	}
+
+	w := &msgResponseWriter{
+		localAddr:  conn.LocalAddr(),
+		remoteAddr: conn.RemoteAddr(),
+	}
+
+	handleRequest(w, msg)
+
+	if w.msg == nil {
+		stream.CancelWrite(doqInternalError)
+		return
+	}
+
+	w.msg.Id = 0
+
+	if idleTimeout > 0 {
+		stream.SetWriteDeadline(time.Now().Add(idleTimeout))
+	}
+
+	if err := writeDoQMsg(stream, w.msg); err != nil {
+		stream.CancelWrite(doqInternalError)
+		return
+	}
+
+	stream.Close()
+}
@@ -91,3 +91,34 @@ func (e *EDNSHandler) AddECS(r *dns.Msg, clientAddr string) {
 	// Append Option to OPT RR
 	opt.Option = append(opt.Option, ecs)
 }
+
+// Return Client Subnet Option of Message if Present
+func findECS(m *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+
+	return nil
+}
+
+// Return Copy of OPT Record Without Client Subnet Option,
+// Original May be Shared with Cached Response
+func withoutECS(opt *dns.OPT) *dns.OPT {
+	stripped := *opt
+	stripped.Option = nil
+
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0SUBNET {
+			stripped.Option = append(stripped.Option, o)
+		}
+	}
+
+	return &stripped
+}
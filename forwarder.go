@@ -1,6 +1,8 @@
 package main
 
 import (
+	"log"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -8,13 +10,16 @@ import (
 )
 
 type ForwarderResolver struct {
-	rules map[string][]string
-	mu    sync.RWMutex
+	rules        map[string]*UpstreamGroup
+	patternRules map[string]*UpstreamGroup
+	patterns     DomainPatterns
+	mu           sync.RWMutex
 }
 
-func NewForwarderResolver(cfg ForwarderConfig) *ForwarderResolver {
+func NewForwarderResolver(cfg ForwarderConfig, upstreamCfg UpstreamConfig) *ForwarderResolver {
 	fr := &ForwarderResolver{
-		rules: make(map[string][]string),
+		rules:        make(map[string]*UpstreamGroup),
+		patternRules: make(map[string]*UpstreamGroup),
 	}
 
 	if !cfg.Enable {
@@ -22,20 +27,82 @@ func NewForwarderResolver(cfg ForwarderConfig) *ForwarderResolver {
 	}
 
 	for _, rule := range cfg.Rules {
-		domain := dns.Fqdn(rule.Domain)
-		fr.rules[domain] = rule.Upstreams
+		domain := forwarderRuleKey(rule.Domain)
+
+		isPattern := isDomainPattern(domain)
+		if isPattern {
+			if _, err := compileDomainPattern(domain, false); err != nil {
+				log.Printf("Error Forwarder Rule '%s': %v", rule.Domain, err)
+				continue
+			}
+		}
+
+		// Bare Forwarder Upstreams are Plain UDP Unless Rule Says Otherwise
+		ruleCfg := upstreamCfg
+		ruleCfg.Mode = "udp"
+		ruleCfg.Domain = rule.ServerName
+
+		if rule.Mode != "" {
+			ruleCfg.Mode = rule.Mode
+		}
+
+		if rule.SkipTLSVerify != nil {
+			ruleCfg.SkipTLSVerify = *rule.SkipTLSVerify
+		}
+
+		if rule.Timeout > 0 {
+			ruleCfg.Timeout = rule.Timeout
+		}
+
+		if rule.Strategy != "" {
+			ruleCfg.Strategy = rule.Strategy
+		}
+
+		if rule.Parallel > 0 {
+			ruleCfg.Parallel = rule.Parallel
+		}
+
+		var upstreams []*Upstream
+		for _, raw := range rule.Upstreams {
+			u, err := NewUpstream(raw, ruleCfg)
+			if err != nil {
+				log.Printf("Error Forwarder Rule '%s': %v", rule.Domain, err)
+				continue
+			}
+
+			upstreams = append(upstreams, u)
+		}
+
+		// Rule Without Valid Upstreams Falls Back to Global Upstreams
+		if len(upstreams) == 0 {
+			log.Printf("Error Forwarder Rule '%s': No Valid Upstreams, Rule Skipped", rule.Domain)
+			continue
+		}
+
+		group, err := NewUpstreamGroup(upstreams, ruleCfg.Strategy, ruleCfg.Parallel)
+		if err != nil {
+			log.Printf("Error Forwarder Rule '%s': %v", rule.Domain, err)
+			continue
+		}
+
+		if isPattern {
+			fr.patterns.Add(domain)
+			fr.patternRules[domain] = group
+		} else {
+			fr.rules[domain] = group
+		}
 	}
 
 	return fr
 }
 
-func (fr *ForwarderResolver) GetUpstream(qName string) ([]string, bool) {
+func (fr *ForwarderResolver) GetUpstream(qName string) (*UpstreamGroup, bool) {
 	fr.mu.RLock()
 	defer fr.mu.RUnlock()
 
 	var bestLen int
 
-	var bestMatch []string
+	var bestMatch *UpstreamGroup
 	found := false
 
 	for domain, upstreams := range fr.rules {
@@ -49,5 +116,72 @@ func (fr *ForwarderResolver) GetUpstream(qName string) ([]string, bool) {
 		}
 	}
 
+	if !found {
+		if pattern, matched := fr.patterns.Match(qName); matched {
+			bestMatch, found = fr.patternRules[pattern]
+		}
+	}
+
 	return bestMatch, found
 }
+
+func (fr *ForwarderResolver) Groups() map[string]*UpstreamGroup {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	groups := make(map[string]*UpstreamGroup, len(fr.rules)+len(fr.patternRules))
+	for domain, group := range fr.rules {
+		groups[domain] = group
+	}
+
+	for pattern, group := range fr.patternRules {
+		groups[pattern] = group
+	}
+
+	return groups
+}
+
+// Return Domains Whose Forwarder Rule was Added, Removed or Changed
+func diffForwarderRules(oldCfg ForwarderConfig, newCfg ForwarderConfig) []string {
+	oldRules := forwarderRulesByDomain(oldCfg)
+	newRules := forwarderRulesByDomain(newCfg)
+
+	var changed []string
+
+	for domain, rule := range oldRules {
+		if newRule, found := newRules[domain]; !found || !reflect.DeepEqual(rule, newRule) {
+			changed = append(changed, domain)
+		}
+	}
+
+	for domain := range newRules {
+		if _, found := oldRules[domain]; !found {
+			changed = append(changed, domain)
+		}
+	}
+
+	return changed
+}
+
+// Later Rule for Same Domain Wins, as in NewForwarderResolver
+func forwarderRulesByDomain(cfg ForwarderConfig) map[string]ForwarderRule {
+	rules := make(map[string]ForwarderRule)
+	if !cfg.Enable {
+		return rules
+	}
+
+	for _, rule := range cfg.Rules {
+		rules[forwarderRuleKey(rule.Domain)] = rule
+	}
+
+	return rules
+}
+
+// Patterns are Kept as Written, Domains are Made Fully Qualified
+func forwarderRuleKey(domain string) string {
+	if isDomainPattern(domain) {
+		return domain
+	}
+
+	return dns.Fqdn(domain)
+}
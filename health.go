@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type HealthChecker struct {
+	upstreams        []*Upstream
+	interval         time.Duration
+	query            *dns.Msg
+	failThreshold    int
+	successThreshold int
+	stop             chan struct{}
+}
+
+func NewHealthChecker(cfg HealthCheckConfig, upstreams []*Upstream) (*HealthChecker, error) {
+	qType, found := dns.StringToType[strings.ToUpper(cfg.QueryType)]
+	if !found {
+		return nil, fmt.Errorf("Error Invalid Health Check Query Type '%s'", cfg.QueryType)
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(cfg.Query), qType)
+
+	hc := &HealthChecker{
+		upstreams:        upstreams,
+		interval:         time.Duration(cfg.Interval) * time.Second,
+		query:            query,
+		failThreshold:    cfg.FailThreshold,
+		successThreshold: cfg.SuccessThreshold,
+		stop:             make(chan struct{}),
+	}
+
+	if hc.interval <= 0 {
+		hc.interval = 10 * time.Second
+	}
+
+	if hc.failThreshold < 1 {
+		hc.failThreshold = 1
+	}
+
+	if hc.successThreshold < 1 {
+		hc.successThreshold = 1
+	}
+
+	return hc, nil
+}
+
+func (hc *HealthChecker) Start() {
+	go hc.run()
+}
+
+func (hc *HealthChecker) run() {
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			var wg sync.WaitGroup
+
+			// Probe All Upstreams Concurrently
+			for _, u := range hc.upstreams {
+				wg.Add(1)
+
+				go func(u *Upstream) {
+					defer wg.Done()
+					hc.probe(u)
+				}(u)
+			}
+
+			wg.Wait()
+
+		case <-hc.stop:
+			// Stop Routine when Stop Signal Recieved
+			return
+		}
+	}
+}
+
+func (hc *HealthChecker) probe(u *Upstream) {
+	q := hc.query.Copy()
+	q.Id = dns.Id()
+
+	start := time.Now()
+
+	resp, err := u.Exchange(context.Background(), q)
+	if err == nil && !isValidResponse(resp) {
+		err = fmt.Errorf("Error Health Check Returned %s", dns.RcodeToString[resp.Rcode])
+	}
+
+	if err == nil {
+		u.observeLatency(time.Since(start))
+	}
+
+	u.recordProbe(err, hc.failThreshold, hc.successThreshold)
+}
+
+func (hc *HealthChecker) Stop() {
+	close(hc.stop)
+}
+
+func (u *Upstream) Healthy() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return !u.unhealthy
+}
+
+func (u *Upstream) recordProbe(err error, failThreshold int, successThreshold int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if err != nil {
+		u.probeSuccesses = 0
+		u.probeFailures++
+
+		if !u.unhealthy && u.probeFailures >= failThreshold {
+			u.unhealthy = true
+			log.Printf("Upstream %s is Unhealthy (Failures: %d): %v", u, u.probeFailures, err)
+		}
+
+		return
+	}
+
+	u.probeFailures = 0
+	u.probeSuccesses++
+
+	if u.unhealthy && u.probeSuccesses >= successThreshold {
+		u.unhealthy = false
+		log.Printf("Upstream %s is Healthy Again", u)
+	}
+}
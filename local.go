@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"log"
 	"net"
 	"os"
 	"runtime"
@@ -14,6 +15,8 @@ import (
 type LocalResolver struct {
 	records         map[string][]net.IP
 	recordWildcards map[string][]net.IP
+	recordPatterns  map[string][]net.IP
+	patterns        DomainPatterns
 	minTTL          uint32
 	mu              sync.RWMutex
 }
@@ -22,6 +25,7 @@ func NewLocalResolver(cfg LocalConfig, minTTL int) *LocalResolver {
 	lr := &LocalResolver{
 		records:         make(map[string][]net.IP),
 		recordWildcards: make(map[string][]net.IP),
+		recordPatterns:  make(map[string][]net.IP),
 		minTTL:          uint32(minTTL),
 	}
 
@@ -92,6 +96,19 @@ func (lr *LocalResolver) addRecord(domain string, ipStr string) {
 }
 
 func (lr *LocalResolver) addRecordIP(domain string, ip net.IP) {
+	if isDomainPattern(domain) {
+		lr.mu.Lock()
+		defer lr.mu.Unlock()
+
+		if err := lr.patterns.Add(domain); err != nil {
+			log.Printf("Error Local Record '%s': %v", domain, err)
+			return
+		}
+
+		lr.recordPatterns[domain] = append(lr.recordPatterns[domain], ip)
+		return
+	}
+
 	isWildcard := false
 	domain = dns.Fqdn(domain)
 
@@ -129,6 +146,13 @@ func (lr *LocalResolver) Resolve(q dns.Question) *dns.Msg {
 		}
 	}
 
+	if !found {
+		var pattern string
+		if pattern, found = lr.patterns.Match(q.Name); found {
+			ips = lr.recordPatterns[pattern]
+		}
+	}
+
 	lr.mu.RUnlock()
 
 	if !found {
@@ -171,3 +195,47 @@ func (lr *LocalResolver) Resolve(q dns.Question) *dns.Msg {
 
 	return m
 }
+
+// Return Domains Whose Records Differ from Other Resolver,
+// Wildcard Domains and Patterns are Returned Separately
+func (lr *LocalResolver) Diff(other *LocalResolver) ([]string, []string, []string) {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+
+	return diffLocalRecords(lr.records, other.records), diffLocalRecords(lr.recordWildcards, other.recordWildcards), diffLocalRecords(lr.recordPatterns, other.recordPatterns)
+}
+
+func diffLocalRecords(a map[string][]net.IP, b map[string][]net.IP) []string {
+	var changed []string
+
+	for domain, ips := range a {
+		if !equalIPs(ips, b[domain]) {
+			changed = append(changed, domain)
+		}
+	}
+
+	for domain := range b {
+		if _, found := a[domain]; !found {
+			changed = append(changed, domain)
+		}
+	}
+
+	return changed
+}
+
+func equalIPs(a []net.IP, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
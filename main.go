@@ -7,17 +7,15 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/miekg/dns"
-	quic "github.com/quic-go/quic-go"
-	"github.com/quic-go/quic-go/http3"
 )
 
 var (
@@ -26,9 +24,9 @@ var (
 )
 
 var (
-	dnsAddreses    []string
-	dohURLs        []string
+	dnsUpstreams   *UpstreamGroup
 	bogusNXDomains []net.IP
+	bogusPolicy    *BlockPolicy
 )
 
 var (
@@ -38,17 +36,19 @@ var (
 )
 
 var (
-	tcpPool      *TCPPool
-	udpPool      *UDPPool
 	bufPool      *sync.Pool
-	dohClient    *http.Client
-	dnsEDNS      *EDNSHandler
+	serverTLS    *tls.Config
 	dnsCache     *DNSCache
 	dnsLocal     *LocalResolver
 	dnsForwarder *ForwarderResolver
+	dnsBlocklist *Blocklist
+	dnsClients   *ClientGroups
+	dnsHealth    *HealthChecker
+	dnsCoalescer = NewRequestCoalescer()
 )
 
-func init() {
+// Parse Command Line Flags and Print Version Banner
+func parseFlags() {
 	var showVersion bool
 
 	flag.StringVar(&configFile, "config", "./dns-proxy.yaml", "Path to YAML configuration file")
@@ -84,89 +84,36 @@ func parseConfig() error {
 		},
 	}
 
-	newDOHDialer := &net.Dialer{
-		Timeout:   time.Duration(newConfig.Upstream.Timeout) * time.Second,
-		KeepAlive: time.Duration(newConfig.Upstream.KeepAlive) * time.Second,
-		Control:   setSocketOptions,
-	}
-
-	newDOHTransportH2 := &http.Transport{
-		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
-			conn, err := newDOHDialer.DialContext(ctx, network, addr)
-			if err != nil {
-				return nil, err
-			}
-
-			if tcpConn, ok := conn.(*net.TCPConn); ok {
-				setTCPOptions(tcpConn)
-			}
-
-			return conn, nil
-		},
-		MaxIdleConns:          newConfig.Upstream.DoH.Idle.MaxConnection,
-		MaxIdleConnsPerHost:   newConfig.Upstream.DoH.Idle.MaxConnectionPerHost,
-		IdleConnTimeout:       time.Duration(newConfig.Upstream.KeepAlive) * time.Second,
-		ResponseHeaderTimeout: time.Duration(newConfig.Upstream.Timeout) * time.Second,
-		ForceAttemptHTTP2:     true,
-		TLSClientConfig: &tls.Config{
-			ServerName:         newConfig.Upstream.Domain,
-			InsecureSkipVerify: newConfig.Upstream.SkipTLSVerify,
-			ClientSessionCache: tls.NewLRUClientSessionCache(newConfig.Upstream.PoolSize),
-		},
-	}
-
-	newDOHTransportH3 := &http3.Transport{
-		EnableDatagrams: true,
-		QUICConfig: &quic.Config{
-			KeepAlivePeriod: time.Duration(newConfig.Upstream.KeepAlive) * time.Second,
-			MaxIdleTimeout:  time.Duration(newConfig.Upstream.Timeout) * time.Second,
-		},
-		TLSClientConfig: &tls.Config{
-			ServerName:         newConfig.Upstream.Domain,
-			InsecureSkipVerify: newConfig.Upstream.SkipTLSVerify,
-			ClientSessionCache: tls.NewLRUClientSessionCache(newConfig.Upstream.PoolSize),
-		},
-	}
-
-	newDOHClient := &http.Client{
-		Transport: &hybridRoundTripper{
-			H2Transport: newDOHTransportH2,
-			H3Transport: newDOHTransportH3,
-		},
-		Timeout: time.Duration(newConfig.Upstream.Timeout) * time.Second,
-	}
-
-	var newDnsAddresses, newDOHURLs []string
+	var newDNSUpstreams []*Upstream
 	for _, remoteAddr := range newConfig.Upstream.Addresses {
 		remoteAddr = strings.TrimSpace(remoteAddr)
 		if remoteAddr == "" {
 			continue
 		}
 
-		// Populate DNS Upstream Targets
-		newDnsAddresses = append(newDnsAddresses, remoteAddr)
+		// Populate DNS Upstream Targets with Their Own Connection Pool
+		upstream, err := NewUpstream(remoteAddr, newConfig.Upstream)
+		if err != nil {
+			return err
+		}
 
-		// Populate DNS-Over-HTTPS URLs
-		url := fmt.Sprintf("https://%s%s", remoteAddr, newConfig.Upstream.DoH.QueryPath)
-		newDOHURLs = append(newDOHURLs, url)
+		newDNSUpstreams = append(newDNSUpstreams, upstream)
 	}
 
-	if len(newDnsAddresses) == 0 {
+	if len(newDNSUpstreams) == 0 {
 		log.Fatal("Error No Valid Remote Addresses Provided")
 	}
 
-	newUDPPool := NewUDPPool(newConfig.Upstream.PoolSize, newDnsAddresses)
-	log.Printf("Initialized: Connection UDP Pool (Size: %d)", newConfig.Upstream.PoolSize)
+	newDNSUpstreamGroup, err := NewUpstreamGroup(newDNSUpstreams, newConfig.Upstream.Strategy, newConfig.Upstream.Parallel)
+	if err != nil {
+		return err
+	}
 
-	var newTCPPool *TCPPool
-	if newConfig.Upstream.Mode == "tcp" || newConfig.Upstream.Mode == "dot" {
-		hostSNI := newConfig.Upstream.Domain
-		if hostSNI == "" && len(newDnsAddresses) > 0 {
-			hostSNI = newDnsAddresses[0]
-		}
+	log.Printf("Initialized: DNS Upstreams (Total: %d, Pool Size: %d, Strategy: %s, Parallel: %d)", len(newDNSUpstreams), newConfig.Upstream.PoolSize, newDNSUpstreamGroup.strategy, newDNSUpstreamGroup.parallel)
 
-		newTCPPool = NewTCPPool(newConfig.Upstream.PoolSize, newDnsAddresses, hostSNI, newConfig.Upstream.Mode)
-		log.Printf("Initialized: Connection TCP Pool (Size: %d)", newConfig.Upstream.PoolSize)
+	newBogusPolicy, err := NewBlockPolicy(newConfig.BogusNXDomain.Response)
+	if err != nil {
+		return err
 	}
 
 	var newBogusNXDomains []net.IP
@@ -178,17 +125,46 @@ func parseConfig() error {
 			}
 		}
 
-		log.Printf("Initialized: Bogus NXDomain Filtering (Total IPs: %d)", len(newBogusNXDomains))
+		log.Printf("Initialized: Bogus NXDomain Filtering (Total IPs: %d, Response: %s)", len(newBogusNXDomains), newBogusPolicy)
+	}
+
+	var newServerTLS *tls.Config
+	if newConfig.Server.TLS.Enable || newConfig.Server.DoH.Enable || newConfig.Server.DoQ.Enable {
+		newServerTLS, err = NewServerTLSConfig(newConfig.Server.TLS)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Initialized: Server TLS Certificate (Minimum Version: %s, Client Auth: %v)", newConfig.Server.TLS.MinVersion, newServerTLS.ClientCAs != nil)
 	}
 
-	newDNSEDNS := NewEDNSHandler(newConfig.EDNS)
 	if newConfig.EDNS.Enable {
 		log.Printf("Initialized: EDNS0 Client Subnet (IPv4 Mask: /%d, IPv6 Mask: /%d)", newConfig.EDNS.IPv4Mask, newConfig.EDNS.IPv6Mask)
 	}
 
-	newDNSCache := NewCache(newConfig.Cache.Size, newConfig.Cache.Shards, newConfig.Cache.MinTTL, newConfig.Cache.NegTTL)
-	if newConfig.Cache.Size > 0 {
-		log.Printf("Initialized: DNS Cache (Size: %d, Shards: %d, Minimum TTL: %ds, Negative TTL: %ds)", newConfig.Cache.Size, newConfig.Cache.Shards, newConfig.Cache.MinTTL, newConfig.Cache.NegTTL)
+	// Keep Existing Cache when its Settings are Unchanged
+	newDNSCache := dnsCache
+	if dnsCache == nil || !reflect.DeepEqual(config.Cache, newConfig.Cache) {
+		newDNSCache = NewCache(newConfig.Cache)
+		if newConfig.Cache.Size > 0 {
+			log.Printf("Initialized: DNS Cache (Size: %d, Shards: %d, Minimum TTL: %ds, Maximum TTL: %ds, Negative TTL: %ds, Maximum Negative TTL: %ds, SERVFAIL TTL: %ds)", newConfig.Cache.Size, newConfig.Cache.Shards, newConfig.Cache.MinTTL, newConfig.Cache.MaxTTL, newConfig.Cache.NegTTL, newConfig.Cache.MaxNegTTL, newConfig.Cache.ServFailTTL)
+
+			if len(newConfig.Cache.Rules) > 0 {
+				log.Printf("Initialized: DNS Cache Rules (Total: %d)", len(newConfig.Cache.Rules))
+			}
+
+			if newConfig.Cache.Persist.Enable {
+				log.Printf("Initialized: DNS Cache Persistence (File: %s, Interval: %ds)", newConfig.Cache.Persist.File, newConfig.Cache.Persist.Interval)
+			}
+
+			if newConfig.Cache.ServeStale.Enable {
+				log.Printf("Initialized: DNS Cache Serve Stale (Max Age: %ds, Client Timeout: %dms)", newConfig.Cache.ServeStale.MaxAge, newConfig.Cache.ServeStale.ClientTimeout)
+			}
+
+			if newConfig.Cache.Prefetch.Enable {
+				log.Printf("Initialized: DNS Cache Prefetch (Threshold: %d%%, Minimum Hits: %d, Concurrency: %d)", newConfig.Cache.Prefetch.Threshold, newConfig.Cache.Prefetch.MinHits, newConfig.Cache.Prefetch.Concurrency)
+			}
+		}
 	}
 
 	newDNSLocal := NewLocalResolver(newConfig.Local, newConfig.Cache.MinTTL)
@@ -196,39 +172,145 @@ func parseConfig() error {
 		log.Printf("Initialized: Local Resolver (Hosts File: %v, Static: %d)", newConfig.Local.UseHostsFile, len(newConfig.Local.StaticRecords))
 	}
 
-	newDNSForwarder := NewForwarderResolver(newConfig.Forwarder)
+	newDNSBlocklist, err := NewBlocklist(newConfig.Blocklist, newConfig.Upstream)
+	if err != nil {
+		return err
+	}
+
+	if newConfig.Blocklist.Enable {
+		log.Printf("Initialized: Blocklist (Rules: %d, Exceptions: %d, Subscriptions: %d, Response: %s)", newDNSBlocklist.Rules(), newDNSBlocklist.Exceptions(), len(newConfig.Blocklist.Subscriptions), newDNSBlocklist.policy)
+	}
+
+	newDNSForwarder := NewForwarderResolver(newConfig.Forwarder, newConfig.Upstream)
 	if newConfig.Forwarder.Enable {
 		log.Printf("Initialized: Forwarder Resolver (Rules: %d)", len(newConfig.Forwarder.Rules))
 	}
 
+	newDNSClients, err := NewClientGroups(newConfig.Clients, newConfig.Upstream, newConfig.EDNS, newConfig.Server.DoH.Path)
+	if err != nil {
+		return err
+	}
+
+	if newConfig.Clients.Enable {
+		log.Printf("Initialized: Client Groups (Total: %d)", len(newConfig.Clients.Groups))
+	}
+
+	var newDNSHealth *HealthChecker
+	if newConfig.HealthCheck.Enable {
+		healthUpstreams := newDNSUpstreamGroup.Upstreams()
+		for _, group := range newDNSForwarder.Groups() {
+			healthUpstreams = append(healthUpstreams, group.Upstreams()...)
+		}
+
+		for _, group := range newDNSClients.Groups() {
+			healthUpstreams = append(healthUpstreams, group.Upstreams()...)
+		}
+
+		newDNSHealth, err = NewHealthChecker(newConfig.HealthCheck, healthUpstreams)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Initialized: Upstream Health Check (Upstreams: %d, Interval: %ds, Query: %s %s)", len(healthUpstreams), newConfig.HealthCheck.Interval, newConfig.HealthCheck.Query, strings.ToUpper(newConfig.HealthCheck.QueryType))
+	}
+
 	configLock.Lock()
 	defer configLock.Unlock()
 
 	if dnsCache != nil {
-		dnsCache.Stop()
+		// Move Live Entries Over when Cache is Rebuilt, Old Cache is
+		// Stopped First So Its Last Snapshot Save Finishes Before
+		// New Cache Starts Saving to Same File
+		if newDNSCache != dnsCache {
+			dnsCache.Stop()
+			moved := newDNSCache.MoveFrom(dnsCache)
+
+			log.Printf("Reloaded: DNS Cache (Moved Entries: %d)", moved)
+		}
+
+		// Drop Entries Answered by Changed Local or Forwarder Rules
+		names, suffixes, patterns := dnsLocal.Diff(newDNSLocal)
+		for _, domain := range diffForwarderRules(config.Forwarder, newConfig.Forwarder) {
+			if isDomainPattern(domain) {
+				patterns = append(patterns, domain)
+			} else {
+				suffixes = append(suffixes, domain)
+			}
+		}
+
+		if len(names) > 0 || len(suffixes) > 0 || len(patterns) > 0 {
+			removed := newDNSCache.Invalidate(names, suffixes, patterns)
+			log.Printf("Reloaded: DNS Cache (Invalidated Entries: %d)", removed)
+		}
+
+		// Drop Views of Changed Client Groups
+		if views := diffClientGroups(config.Clients, newConfig.Clients); len(views) > 0 {
+			removed := newDNSCache.InvalidateViews(views)
+			log.Printf("Reloaded: DNS Cache (Invalidated Client Group Entries: %d)", removed)
+		}
+	}
+
+	if dnsHealth != nil {
+		dnsHealth.Stop()
+	}
+
+	if dnsBlocklist != nil {
+		dnsBlocklist.Stop()
+	}
+
+	// Queries Hold Config Lock While Using Upstreams,
+	// So Replaced Upstreams Have No Exchange In-Flight
+	if dnsUpstreams != nil {
+		dnsUpstreams.Close()
+
+		for _, group := range dnsForwarder.Groups() {
+			group.Close()
+		}
+
+		for _, group := range dnsClients.Groups() {
+			group.Close()
+		}
 	}
 
 	config = newConfig
 
 	bufPool = newBufPool
-	dohClient = newDOHClient
+	serverTLS = newServerTLS
 
-	dnsAddreses = newDnsAddresses
-	dohURLs = newDOHURLs
+	dnsUpstreams = newDNSUpstreamGroup
 	bogusNXDomains = newBogusNXDomains
+	bogusPolicy = newBogusPolicy
 
-	udpPool = newUDPPool
-	tcpPool = newTCPPool
+	// Load Snapshot Only on Startup
+	if dnsCache == nil {
+		if err := newDNSCache.Load(); err != nil {
+			log.Printf("Error Failed to Load DNS Cache Snapshot: %v", err)
+		}
+	}
+
+	if newDNSCache != dnsCache {
+		newDNSCache.Start()
+	}
 
-	dnsEDNS = newDNSEDNS
 	dnsCache = newDNSCache
 	dnsLocal = newDNSLocal
 	dnsForwarder = newDNSForwarder
+	dnsClients = newDNSClients
+	dnsBlocklist = newDNSBlocklist
+	dnsHealth = newDNSHealth
+
+	if dnsHealth != nil {
+		dnsHealth.Start()
+	}
+
+	dnsBlocklist.Start()
 
 	return nil
 }
 
 func main() {
+	parseFlags()
+
 	if err := parseConfig(); err != nil {
 		log.Fatalf("Error Initial Configuration Load: %v", err)
 	}
@@ -239,7 +321,37 @@ func main() {
 		go startListener("udp", addr)
 		go startListener("tcp", addr)
 
-		log.Printf("DNS Proxy Listening on %s -> %v [%s]", addr, dnsAddreses, strings.ToUpper(config.Upstream.Mode))
+		log.Printf("DNS Proxy Listening on %s -> %v", addr, dnsUpstreams)
+	}
+
+	if config.Server.TLS.Enable {
+		for _, addr := range config.Server.TLS.Listen {
+			go startListener("tcp-tls", addr)
+
+			log.Printf("DNS Proxy Listening on %s (TLS) -> %v", addr, dnsUpstreams)
+		}
+	}
+
+	if config.Server.DoH.Enable {
+		for _, addr := range config.Server.DoH.Listen {
+			go startDoHListener(addr)
+
+			log.Printf("DNS Proxy Listening on https://%s%s (HTTP/3: %v) -> %v", addr, config.Server.DoH.Path, config.Server.DoH.HTTP3, dnsUpstreams)
+		}
+	}
+
+	if config.Server.DoQ.Enable {
+		for _, addr := range config.Server.DoQ.Listen {
+			go startDoQListener(addr, config.Server.DoQ)
+
+			log.Printf("DNS Proxy Listening on %s (QUIC) -> %v", addr, dnsUpstreams)
+		}
+	}
+
+	if config.Metrics.Enable {
+		go startMetricsListener(config.Metrics.Listen, config.Metrics.Path)
+
+		log.Printf("Metrics Listening on http://%s%s", config.Metrics.Listen, config.Metrics.Path)
 	}
 
 	sig := make(chan os.Signal, 1)
@@ -260,6 +372,17 @@ func main() {
 	}
 
 	fmt.Println("")
+
+	configLock.Lock()
+
+	dnsCache.Stop()
+
+	if err := dnsCache.Save(); err != nil {
+		log.Printf("Error Failed to Save DNS Cache Snapshot: %v", err)
+	}
+
+	configLock.Unlock()
+
 	log.Println("Shutdown Complete")
 }
 
@@ -286,6 +409,14 @@ func startListener(netType string, addr string) {
 		}
 
 		server = &dns.Server{Listener: l, Net: netType}
+
+	case "tcp-tls":
+		l, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			log.Fatalf("Failed to Listen on '%s': %v", strings.ToUpper(netType), err)
+		}
+
+		server = &dns.Server{Listener: tls.NewListener(l, listenerTLSConfig("dot")), Net: netType}
 	}
 
 	if err := server.ActivateAndServe(); err != nil {
@@ -300,53 +431,47 @@ func handleRequest(w dns.ResponseWriter, r *dns.Msg) {
 	var err error
 	var resp *dns.Msg
 
-	if config.Upstream.DisableIPv6 && len(r.Question) > 0 && r.Question[0].Qtype == dns.TypeAAAA {
-		resp := new(dns.Msg)
-
-		resp.SetReply(r)
-		resp.Compress = config.Server.Compress
+	group := dnsClients.Match(w)
 
-		w.WriteMsg(resp)
+	if config.Upstream.DisableIPv6 && len(r.Question) > 0 && r.Question[0].Qtype == dns.TypeAAAA {
+		writeResponse(w, r, new(dns.Msg))
 		return
 	}
 
 	if localResp := dnsLocal.Resolve(r.Question[0]); localResp != nil {
-		localResp.SetReply(r)
-		localResp.Compress = config.Server.Compress
-
-		w.WriteMsg(localResp)
+		writeResponse(w, r, localResp)
 		return
 	}
 
-	cachedResp := dnsCache.Get(r)
-	if cachedResp != nil {
-		cachedResp.SetReply(r)
-		cachedResp.Compress = config.Server.Compress
-
-		w.WriteMsg(cachedResp)
-		return
+	if group.blocklist {
+		if policy := dnsBlocklist.Match(r.Question[0].Name); policy != nil {
+			writeResponse(w, r, policy.Response(r))
+			return
+		}
 	}
 
-	if dnsEDNS != nil {
-		dnsEDNS.AddECS(r, w.RemoteAddr().String())
+	// Client Subnet is Part of Cache Key, It is Added to Copy of Request
+	// So Reply Still Follows What Client Sent, Including Whether it
+	// Spoke EDNS at All
+	q := r
+	if group.edns != nil {
+		q = r.Copy()
+		group.edns.AddECS(q, w.RemoteAddr().String())
 	}
 
-	forwardFound := false
-	if config.Forwarder.Enable {
-		if targets, found := dnsForwarder.GetUpstream(r.Question[0].Name); found {
-			resp, err = forwardUDP(r, targets)
-			forwardFound = true
+	if target, found := safeSearchTarget(q.Question[0].Name, group.safeSearch); found {
+		resp, err = resolveSafeSearch(q, target, group)
+	} else {
+		cachedResp := dnsCache.Get(q, group.view)
+		if cachedResp != nil {
+			writeResponse(w, r, cachedResp)
+			return
 		}
-	}
 
-	if !forwardFound {
-		switch config.Upstream.Mode {
-		case "doh":
-			resp, err = forwardDoH(r, dohURLs)
-		case "tcp", "dot":
-			resp, err = forwardTCP(r)
-		case "udp":
-			resp, err = forwardUDP(r, nil)
+		if staleResp := dnsCache.GetStale(q, group.view); staleResp != nil {
+			resp, err = resolveWithStale(q, group, staleResp, dnsCache.StaleTimeout())
+		} else {
+			resp, err = resolveUpstream(q, group)
 		}
 	}
 
@@ -354,16 +479,38 @@ func handleRequest(w dns.ResponseWriter, r *dns.Msg) {
 		log.Printf("Error DNS Upstream Server: %v", err)
 
 		failMsg := new(dns.Msg)
-		failMsg.SetRcode(r, dns.RcodeServerFailure)
-		failMsg.Compress = config.Server.Compress
+		failMsg.Rcode = dns.RcodeServerFailure
 
-		w.WriteMsg(failMsg)
+		writeResponse(w, r, failMsg)
 		return
 	}
 
-	if resp != nil {
-		if config.BogusNXDomain.Enable {
-			checkBogusNXDomain(resp)
+	writeResponse(w, r, resp)
+}
+
+// Forward Query to Its Upstream Group and Cache The Response,
+// Identical In-Flight Queries Share One Upstream Request.
+// Forwarder Rules Take Precedence Over Client Group Upstreams
+func resolveUpstream(r *dns.Msg, group *ClientGroup) (*dns.Msg, error) {
+	upstreams := dnsUpstreams
+	if group.upstreams != nil {
+		upstreams = group.upstreams
+	}
+
+	if config.Forwarder.Enable {
+		if targets, found := dnsForwarder.GetUpstream(r.Question[0].Name); found {
+			upstreams = targets
+		}
+	}
+
+	return dnsCoalescer.Do(requestKey(r, group.view), func() (*dns.Msg, error) {
+		resp, err := forwardUpstreams(r, upstreams)
+		if err != nil {
+			return nil, err
+		}
+
+		if config.BogusNXDomain.Enable && isBogusNXDomain(resp) {
+			resp = bogusPolicy.Response(r)
 		}
 
 		if config.Upstream.DisableIPv6 {
@@ -372,11 +519,46 @@ func handleRequest(w dns.ResponseWriter, r *dns.Msg) {
 			resp.Extra = filterIPv6Records(resp.Extra)
 		}
 
-		dnsCache.Set(resp)
+		dnsCache.Set(r, resp, group.view)
+
+		return resp, nil
+	})
+}
+
+// Wait for Upstream Until Timeout Then Answer with Stale Entry,
+// Resolution Keeps Running in Background to Refresh The Cache
+func resolveWithStale(r *dns.Msg, group *ClientGroup, staleResp *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	type result struct {
+		resp *dns.Msg
+		err  error
 	}
 
-	resp.SetReply(r)
-	resp.Compress = config.Server.Compress
+	done := make(chan result, 1)
+
+	q := r.Copy()
+	view := group.view
+
+	// Resolution May Outlive The Request, So It Takes Its Own Config Lock
+	// And Looks Up Client Group Again in Case Configuration was Reloaded
+	go func() {
+		configLock.RLock()
+		defer configLock.RUnlock()
+
+		resp, err := resolveUpstream(q, dnsClients.Group(view))
+		done <- result{resp, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		if res.err == nil && res.resp.Rcode != dns.RcodeServerFailure {
+			return res.resp, nil
+		}
+
+	case <-timer.C:
+	}
 
-	w.WriteMsg(resp)
+	return staleResp, nil
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+)
+
+func startMetricsListener(addr string, path string) {
+	lc := net.ListenConfig{
+		Control: setSocketOptions,
+	}
+
+	l, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to Listen on 'METRICS': %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, handleMetricsRequest)
+
+	if err := http.Serve(l, mux); err != nil {
+		log.Fatalf("Failed to Start 'METRICS' Listener: %s", err.Error())
+	}
+}
+
+func handleMetricsRequest(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(rw)
+}
+
+// Write Metrics Using Prometheus Text Exposition Format
+func writeMetrics(w io.Writer) {
+	configLock.RLock()
+
+	// Group Labels are Prefixed by Kind, So Forwarder Domain and
+	// Client Group with Same Name Do Not Share Series
+	groups := map[string]*UpstreamGroup{"default": dnsUpstreams}
+	for domain, group := range dnsForwarder.Groups() {
+		groups["forwarder:"+domain] = group
+	}
+
+	for name, group := range dnsClients.Groups() {
+		groups["client:"+name] = group
+	}
+
+	configLock.RUnlock()
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# HELP dns_proxy_upstream_healthy Whether the upstream passes active health checks.")
+	fmt.Fprintln(w, "# TYPE dns_proxy_upstream_healthy gauge")
+	for _, name := range names {
+		for _, u := range groups[name].Upstreams() {
+			fmt.Fprintf(w, "dns_proxy_upstream_healthy{group=%q,upstream=%q} %d\n", name, u.String(), boolToInt(u.Healthy()))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP dns_proxy_upstream_circuit_open Whether the upstream circuit breaker is open or half-open.")
+	fmt.Fprintln(w, "# TYPE dns_proxy_upstream_circuit_open gauge")
+	for _, name := range names {
+		for _, u := range groups[name].Upstreams() {
+			fmt.Fprintf(w, "dns_proxy_upstream_circuit_open{group=%q,upstream=%q} %d\n", name, u.String(), boolToInt(u.breaker.Open()))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP dns_proxy_upstream_latency_seconds Moving average of the upstream response time.")
+	fmt.Fprintln(w, "# TYPE dns_proxy_upstream_latency_seconds gauge")
+	for _, name := range names {
+		for _, u := range groups[name].Upstreams() {
+			fmt.Fprintf(w, "dns_proxy_upstream_latency_seconds{group=%q,upstream=%q} %g\n", name, u.String(), u.Latency().Seconds())
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP dns_proxy_coalesced_queries_total Queries answered by waiting on an identical in-flight upstream request.")
+	fmt.Fprintln(w, "# TYPE dns_proxy_coalesced_queries_total counter")
+	fmt.Fprintf(w, "dns_proxy_coalesced_queries_total %d\n", dnsCoalescer.Coalesced())
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Domain Patterns are Either Regular Expressions Between Slashes
+// (/^ad[0-9]+\./) or Globs with * and ? Wildcards (*-cdn.example.*),
+// Both are Matched Against Lowercase Name Without Trailing Dot.
+//
+// When Resolving a Name Rules are Tried in Order of Precedence:
+// Exact > Longest Suffix (*.example.com) > Glob > Regex,
+// Glob and Regex Rules are Tried in Configuration Order
+const (
+	patternGlob = iota + 1
+	patternRegex
+)
+
+type domainPattern struct {
+	pattern string
+	kind    int
+	re      *regexp.Regexp
+}
+
+// Check if Domain is Glob or Regex Rather than Exact or Suffix Rule
+func isDomainPattern(domain string) bool {
+	if len(domain) > 2 && domain[0] == '/' && domain[len(domain)-1] == '/' {
+		return true
+	}
+
+	return strings.ContainsAny(strings.TrimPrefix(domain, "*."), "*?")
+}
+
+// Compile Pattern, Glob with Subdomains Also Matches Names Below It
+func compileDomainPattern(pattern string, subdomains bool) (*domainPattern, error) {
+	p := &domainPattern{
+		pattern: pattern,
+	}
+
+	if len(pattern) > 2 && pattern[0] == '/' && pattern[len(pattern)-1] == '/' {
+		re, err := regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("Error Invalid Domain Regex '%s': %w", pattern, err)
+		}
+
+		p.kind = patternRegex
+		p.re = re
+
+		return p, nil
+	}
+
+	glob := strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if glob == "" {
+		return nil, fmt.Errorf("Error Invalid Domain Glob '%s'", pattern)
+	}
+
+	var expr strings.Builder
+
+	expr.WriteString("^")
+	if subdomains {
+		expr.WriteString(`(?:.*\.)?`)
+	}
+
+	for _, c := range glob {
+		switch c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	expr.WriteString("$")
+
+	p.kind = patternGlob
+	p.re = regexp.MustCompile(expr.String())
+
+	return p, nil
+}
+
+func (p *domainPattern) match(name string) bool {
+	return p.re.MatchString(name)
+}
+
+// Ordered Glob and Regex Rules, Matching Returns The Pattern
+// Which is Used by Callers as Key to Their Rule Data
+type DomainPatterns struct {
+	globs   []*domainPattern
+	regexps []*domainPattern
+	known   map[string]bool
+}
+
+func (dp *DomainPatterns) Add(pattern string) error {
+	if dp.known[pattern] {
+		return nil
+	}
+
+	p, err := compileDomainPattern(pattern, false)
+	if err != nil {
+		return err
+	}
+
+	if dp.known == nil {
+		dp.known = make(map[string]bool)
+	}
+
+	dp.known[pattern] = true
+
+	if p.kind == patternGlob {
+		dp.globs = append(dp.globs, p)
+	} else {
+		dp.regexps = append(dp.regexps, p)
+	}
+
+	return nil
+}
+
+func (dp *DomainPatterns) Match(qName string) (string, bool) {
+	if len(dp.known) == 0 {
+		return "", false
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(qName, "."))
+
+	for _, p := range dp.globs {
+		if p.match(name) {
+			return p.pattern, true
+		}
+	}
+
+	for _, p := range dp.regexps {
+		if p.match(name) {
+			return p.pattern, true
+		}
+	}
+
+	return "", false
+}
+
+func (dp *DomainPatterns) Len() int {
+	return len(dp.known)
+}
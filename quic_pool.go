@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+type QUICPool struct {
+	conn          *quic.Conn
+	address       string
+	host          string
+	skipTLSVerify bool
+	timeout       time.Duration
+	keepAlive     time.Duration
+	sessionCache  tls.ClientSessionCache
+	dial          *quicDial
+	closed        bool
+	mu            sync.Mutex
+}
+
+// Dial in Progress, Shared by Callers Arriving While It Runs
+type quicDial struct {
+	done chan struct{}
+	conn *quic.Conn
+	err  error
+}
+
+func NewQUICPool(size int, addr string, host string, skipTLSVerify bool, timeout time.Duration, keepAlive time.Duration) *QUICPool {
+	return &QUICPool{
+		address:       addr,
+		host:          host,
+		skipTLSVerify: skipTLSVerify,
+		timeout:       timeout,
+		keepAlive:     keepAlive,
+		sessionCache:  tls.NewLRUClientSessionCache(size),
+	}
+}
+
+func (p *QUICPool) NewConn() (*quic.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	// Dial Early to Send Query in 0-RTT
+	// When Session Ticket Already Cached
+	tlsConfig := &tls.Config{
+		ServerName:         p.host,
+		InsecureSkipVerify: p.skipTLSVerify,
+		NextProtos:         []string{"doq"},
+		ClientSessionCache: p.sessionCache,
+	}
+
+	return quic.DialAddrEarly(ctx, p.address, tlsConfig, &quic.Config{
+		KeepAlivePeriod: p.keepAlive,
+		MaxIdleTimeout:  p.keepAlive + p.timeout,
+	})
+}
+
+func (p *QUICPool) Get() (*quic.Conn, bool, error) {
+	p.mu.Lock()
+
+	if p.closed {
+		p.mu.Unlock()
+		return nil, false, net.ErrClosed
+	}
+
+	// Reuse Connection While It is Still Alive
+	if p.conn != nil {
+		if p.conn.Context().Err() == nil {
+			c := p.conn
+			p.mu.Unlock()
+
+			return c, true, nil
+		}
+
+		p.conn = nil
+	}
+
+	// Handshake Runs Outside The Lock, Callers Arriving
+	// Meanwhile Wait for It Instead of Dialing Their Own
+	if d := p.dial; d != nil {
+		p.mu.Unlock()
+		<-d.done
+
+		return d.conn, false, d.err
+	}
+
+	d := &quicDial{
+		done: make(chan struct{}),
+	}
+
+	p.dial = d
+	p.mu.Unlock()
+
+	d.conn, d.err = p.NewConn()
+
+	p.mu.Lock()
+	p.dial = nil
+	if d.err == nil {
+		if p.closed {
+			// Pool Closed While Dialing, Do Not Keep Connection Alive
+			d.conn.CloseWithError(0, "")
+			d.conn, d.err = nil, net.ErrClosed
+		} else {
+			p.conn = d.conn
+		}
+	}
+	p.mu.Unlock()
+
+	close(d.done)
+
+	return d.conn, false, d.err
+}
+
+func (p *QUICPool) Remove(c *quic.Conn) {
+	if c == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == c {
+		p.conn = nil
+	}
+
+	c.CloseWithError(0, "")
+}
+
+// Close Pooled Connection, Stopping Its Keep-Alives
+func (p *QUICPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+
+	if p.conn != nil {
+		p.conn.CloseWithError(0, "")
+		p.conn = nil
+	}
+}
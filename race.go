@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+type raceResult struct {
+	resp *dns.Msg
+	err  error
+}
+
+// Query All Upstreams at The Same Time and Return
+// First Valid Answer, The Rest are Cancelled
+func raceUpstreams(m *dns.Msg, upstreams []*Upstream) (*dns.Msg, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan raceResult, len(upstreams))
+
+	for _, u := range upstreams {
+		go func(u *Upstream, q *dns.Msg) {
+			resp, err := exchangeUpstream(ctx, q, u)
+			results <- raceResult{resp: resp, err: err}
+		}(u, m.Copy())
+	}
+
+	var fallback *dns.Msg
+	var lastErr error
+
+	for range upstreams {
+		result := <-results
+		if result.err != nil {
+			lastErr = result.err
+			continue
+		}
+
+		if isValidResponse(result.resp) {
+			return result.resp, nil
+		}
+
+		// Keep Invalid Answer in Case No Upstream Gives Valid One
+		fallback = result.resp
+	}
+
+	if fallback != nil {
+		return fallback, nil
+	}
+
+	return nil, lastErr
+}
+
+func isValidResponse(resp *dns.Msg) bool {
+	return resp.Rcode != dns.RcodeServerFailure && resp.Rcode != dns.RcodeRefused
+}
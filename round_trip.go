@@ -30,3 +30,17 @@ func (rt *hybridRoundTripper) RoundTrip(req *http.Request) (*http.Response, erro
 
 	return resp, nil
 }
+
+// Close Idle Connections of Both Transports, Called by
+// http.Client.CloseIdleConnections when Upstream is Closed
+func (rt *hybridRoundTripper) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+
+	for _, t := range []http.RoundTripper{rt.H3Transport, rt.H2Transport} {
+		if c, ok := t.(closeIdler); ok {
+			c.CloseIdleConnections()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const safeSearchTTL = 300
+
+// Search Engines Enforcing Safe Search by Resolving to Their Restricted Host,
+// Only YouTube Has Separate Moderate Mode, Others Use Same Host for Both
+var safeSearchRules = []struct {
+	re       *regexp.Regexp
+	strict   string
+	moderate string
+}{
+	{
+		re:       regexp.MustCompile(`^(www\.)?google\.(com|[a-z]{2}|co\.[a-z]{2}|com\.[a-z]{2})$`),
+		strict:   "forcesafesearch.google.com.",
+		moderate: "forcesafesearch.google.com.",
+	},
+	{
+		re:       regexp.MustCompile(`^((www|m)\.youtube\.com|youtube(i)?\.googleapis\.com|www\.youtube-nocookie\.com)$`),
+		strict:   "restrict.youtube.com.",
+		moderate: "restrictmoderate.youtube.com.",
+	},
+	{
+		re:       regexp.MustCompile(`^(www\.)?bing\.com$`),
+		strict:   "strict.bing.com.",
+		moderate: "strict.bing.com.",
+	},
+	{
+		re:       regexp.MustCompile(`^(www\.|start\.)?duckduckgo\.com$`),
+		strict:   "safe.duckduckgo.com.",
+		moderate: "safe.duckduckgo.com.",
+	},
+}
+
+// Find Restricted Host for Search Engine Name Under Safe Search Policy
+func safeSearchTarget(qName string, policy string) (string, bool) {
+	if policy == "" {
+		return "", false
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(qName, "."))
+
+	for _, rule := range safeSearchRules {
+		if !rule.re.MatchString(name) {
+			continue
+		}
+
+		if policy == "moderate" {
+			return rule.moderate, true
+		}
+
+		return rule.strict, true
+	}
+
+	return "", false
+}
+
+// Answer with CNAME to Restricted Host Followed by Its Records
+func resolveSafeSearch(r *dns.Msg, target string, group *ClientGroup) (*dns.Msg, error) {
+	q := r.Copy()
+	q.Question[0].Name = target
+
+	resp := dnsCache.Get(q, group.view)
+	if resp == nil {
+		var err error
+
+		resp, err = resolveUpstream(q, group)
+		if err != nil {
+			return nil, err
+		}
+
+		resp = resp.Copy()
+	}
+
+	cname := &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   r.Question[0].Name,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    safeSearchTTL,
+		},
+		Target: target,
+	}
+
+	resp.Answer = append([]dns.RR{cname}, resp.Answer...)
+
+	return resp, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"time"
@@ -8,67 +9,66 @@ import (
 	"github.com/miekg/dns"
 )
 
-func forwardTCP(m *dns.Msg) (*dns.Msg, error) {
-	var conn *dns.Conn
-	var reused bool
-
-	var err error
-	var lastErr error
-
-	attempts := 0
-	maxAttempts := config.Upstream.MaxAttempts
-
-	if maxAttempts < 1 {
-		maxAttempts = 1
-	}
-
-	for attempts < maxAttempts {
-		ctxTimeout := time.Now().Add(time.Duration(config.Upstream.Timeout) * time.Second)
-
-		conn, reused, err = tcpPool.Get()
+func forwardTCP(ctx context.Context, m *dns.Msg, u *Upstream) (*dns.Msg, error) {
+	for {
+		conn, reused, err := u.tcpPool.Get()
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("[TCP] Error Failed to Dial DNS Upstream %s: %w", u.Address, err)
 		}
 
+		ctxTimeout := time.Now().Add(u.Timeout)
+
 		conn.SetWriteDeadline(ctxTimeout)
 		conn.SetReadDeadline(ctxTimeout)
 
+		// Interrupt Pending Read/Write when Context is Cancelled
+		stop := context.AfterFunc(ctx, func() {
+			conn.SetDeadline(time.Now())
+		})
+
 		if err := conn.WriteMsg(m); err != nil {
+			stop()
 			conn.Close()
-			tcpPool.Return(nil)
 
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			// Stale Pooled Connection, Retry with Fresh One
 			if reused {
 				continue
 			}
 
-			lastErr = fmt.Errorf("[TCP] Error Failed to Write: %w", err)
-			attempts++
-
-			continue
+			return nil, fmt.Errorf("[TCP] Error Failed to Write: %w", err)
 		}
 
 		resp, err := conn.ReadMsg()
 		if err != nil {
+			stop()
 			conn.Close()
-			tcpPool.Return(nil)
 
-			if reused && (err == io.EOF || isNetworkError(err)) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			if reused && (err == io.EOF || (isNetworkError(err) && !isTimeoutError(err))) {
 				continue
 			}
 
-			lastErr = fmt.Errorf("[TCP] Error Failed to Read: %w", err)
-			attempts++
+			return nil, fmt.Errorf("[TCP] Error Failed to Read: %w", err)
+		}
 
-			continue
+		// Connection State is Unknown if Cancel Already Fired
+		if !stop() {
+			conn.Close()
+			return resp, nil
 		}
 
 		conn.SetWriteDeadline(time.Time{})
 		conn.SetReadDeadline(time.Time{})
 
-		tcpPool.Return(conn)
+		u.tcpPool.Return(conn)
 
 		return resp, nil
 	}
-
-	return nil, fmt.Errorf("[TCP] Error DNS Upstream Failed After %d Attempts: %v", attempts, lastErr)
 }
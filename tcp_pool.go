@@ -2,47 +2,39 @@ package main
 
 import (
 	"crypto/tls"
-	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
 type TCPPool struct {
-	conns     chan *dns.Conn
-	addresses []string
-	host      string
-	mode      string
-	capacity  int
+	conns         chan *dns.Conn
+	address       string
+	host          string
+	mode          string
+	skipTLSVerify bool
+	timeout       time.Duration
+	sessionCache  tls.ClientSessionCache
+	capacity      int
+	closed        atomic.Bool
 }
 
-func NewTCPPool(size int, addrs []string, host string, mode string) *TCPPool {
+func NewTCPPool(size int, addr string, host string, mode string, skipTLSVerify bool, timeout time.Duration) *TCPPool {
 	return &TCPPool{
-		conns:     make(chan *dns.Conn, size),
-		addresses: addrs,
-		host:      host,
-		mode:      mode,
-		capacity:  size,
+		conns:         make(chan *dns.Conn, size),
+		address:       addr,
+		host:          host,
+		mode:          mode,
+		skipTLSVerify: skipTLSVerify,
+		timeout:       timeout,
+		sessionCache:  tls.NewLRUClientSessionCache(128),
+		capacity:      size,
 	}
 }
 
 func (p *TCPPool) NewConn() (*dns.Conn, error) {
-	var lastErr error
-
-	for _, addr := range p.addresses {
-		conn, err := p.Dial(addr)
-		if err == nil {
-			return conn, nil
-		}
-
-		lastErr = err
-	}
-
-	return nil, fmt.Errorf("Error Failed to Dial DNS Upstreams: %v", lastErr)
-}
-
-func (p *TCPPool) Dial(addr string) (*dns.Conn, error) {
 	c := new(dns.Client)
 	c.Net = "tcp"
 
@@ -50,18 +42,18 @@ func (p *TCPPool) Dial(addr string) (*dns.Conn, error) {
 		c.Net = "tcp-tls"
 		c.TLSConfig = &tls.Config{
 			ServerName:         p.host,
-			InsecureSkipVerify: config.Upstream.SkipTLSVerify,
-			ClientSessionCache: tls.NewLRUClientSessionCache(128),
+			InsecureSkipVerify: p.skipTLSVerify,
+			ClientSessionCache: p.sessionCache,
 		}
 	}
 
 	c.Dialer = &net.Dialer{
-		Timeout:   time.Duration(config.Upstream.Timeout) * time.Second,
+		Timeout:   p.timeout,
 		KeepAlive: time.Duration(config.Upstream.KeepAlive) * time.Second,
 		Control:   setSocketOptions,
 	}
 
-	conn, err := c.Dial(addr)
+	conn, err := c.Dial(p.address)
 	if err == nil {
 		if tcpConn, ok := conn.Conn.(*net.TCPConn); ok {
 			setTCPOptions(tcpConn)
@@ -98,9 +90,38 @@ func (p *TCPPool) Return(c *dns.Conn) {
 		return
 	}
 
+	if p.closed.Load() {
+		c.Close()
+		return
+	}
+
 	select {
 	case p.conns <- c:
 	default:
 		c.Close()
 	}
+
+	// Pool Closed While Returning, Drain Connection Just Pooled
+	if p.closed.Load() {
+		p.drain()
+	}
+}
+
+// Close Pooled Connections, Connections Returned Later are Closed Too
+func (p *TCPPool) Close() {
+	p.closed.Store(true)
+	p.drain()
+}
+
+func (p *TCPPool) drain() {
+	for {
+		select {
+		case c := <-p.conns:
+			if c != nil {
+				c.Close()
+			}
+		default:
+			return
+		}
+	}
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func NewServerTLSConfig(cfg ServerTLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error Failed to Load TLS Certificate: %w", err)
+	}
+
+	minVersion, err := parseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+	}
+
+	// Enable Mutual TLS when Client CA is Configured
+	caFile := strings.TrimSpace(cfg.ClientCAFile)
+	if caFile != "" {
+		caData, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error Failed to Read TLS Client CA: %w", err)
+		}
+
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("Error No Valid Certificate Found in TLS Client CA '%s'", caFile)
+		}
+
+		tlsConfig.ClientCAs = caPool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimSpace(version) {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, fmt.Errorf("Error Invalid TLS Minimum Version '%s'", version)
+}
+
+// Build Listener TLS Configuration that Always Resolve
+// The Latest Loaded Certificate, So SIGHUP Reload is Picked Up
+// By Already Running Listeners on Next Handshake
+func listenerTLSConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			configLock.RLock()
+			current := serverTLS
+			configLock.RUnlock()
+
+			if current == nil {
+				return nil, errors.New("Error TLS Certificate is Not Configured")
+			}
+
+			tlsConfig := current.Clone()
+			tlsConfig.NextProtos = nextProtos
+
+			return tlsConfig, nil
+		},
+	}
+}
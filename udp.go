@@ -1,89 +1,90 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
-func forwardUDP(m *dns.Msg, overrides []string) (*dns.Msg, error) {
-	var conn *dns.Conn
-	var reused bool
-
-	var err error
-	var lastErr error
-
-	attempts := 0
-	maxAttempts := config.Upstream.MaxAttempts
-
-	if maxAttempts < 1 {
-		maxAttempts = 1
-	}
-
-	for attempts < maxAttempts {
-		ctxTimeout := time.Now().Add(time.Duration(config.Upstream.Timeout) * time.Second)
-
-		if len(overrides) > 0 {
-			addr := overrides[attempts%len(overrides)]
-
-			reused = false
-			conn, err = udpPool.Dial(addr)
-			if err != nil {
-				lastErr = err
-				attempts++
-
-				continue
-			}
-		} else {
-			conn, reused, err = udpPool.Get()
-			if err != nil {
-				return nil, err
-			}
+func forwardUDP(ctx context.Context, m *dns.Msg, u *Upstream) (*dns.Msg, error) {
+	for {
+		conn, reused, err := u.udpPool.Get()
+		if err != nil {
+			return nil, fmt.Errorf("[UDP] Error Failed to Dial DNS Upstream %s: %w", u.Address, err)
 		}
 
+		ctxTimeout := time.Now().Add(u.Timeout)
+
 		conn.SetWriteDeadline(ctxTimeout)
 		conn.SetReadDeadline(ctxTimeout)
 
+		// Interrupt Pending Read/Write when Context is Cancelled
+		stop := context.AfterFunc(ctx, func() {
+			conn.SetDeadline(time.Now())
+		})
+
 		if err := conn.WriteMsg(m); err != nil {
+			stop()
 			conn.Close()
-			udpPool.Return(nil)
 
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			// Stale Pooled Connection, Retry with Fresh One
 			if reused {
 				continue
 			}
 
-			lastErr = err
-			attempts++
-			continue
+			return nil, fmt.Errorf("[UDP] Error Failed to Write: %w", err)
 		}
 
 		resp, err := conn.ReadMsg()
 		if err != nil {
+			stop()
 			conn.Close()
-			udpPool.Return(nil)
 
-			if reused && (err == io.EOF || isNetworkError(err)) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			if reused && (err == io.EOF || (isNetworkError(err) && !isTimeoutError(err))) {
 				continue
 			}
 
-			lastErr = err
-			attempts++
-			continue
+			return nil, fmt.Errorf("[UDP] Error Failed to Read: %w", err)
 		}
 
-		conn.SetWriteDeadline(time.Time{})
-		conn.SetReadDeadline(time.Time{})
-
-		if len(overrides) > 0 {
+		// Connection State is Unknown if Cancel Already Fired
+		if !stop() {
 			conn.Close()
 		} else {
-			udpPool.Return(conn)
+			conn.SetWriteDeadline(time.Time{})
+			conn.SetReadDeadline(time.Time{})
+
+			u.udpPool.Return(conn)
+		}
+
+		// Truncated Response, Retry Same Query Over TCP. When TCP
+		// Fails Truncated Answer is Returned So Client Can Retry Itself
+		if resp.Truncated && ctx.Err() == nil {
+			tcpResp, err := forwardTCP(ctx, m, u)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+
+				log.Printf("Error Failed to Retry Truncated Answer Over TCP: %v", err)
+				return resp, nil
+			}
+
+			return tcpResp, nil
 		}
 
 		return resp, nil
 	}
-
-	return nil, fmt.Errorf("[UDP] Error DNS Upstream Failed After %d Attempts: %v", attempts, lastErr)
 }
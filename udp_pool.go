@@ -1,53 +1,41 @@
 package main
 
 import (
-	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
 type UDPPool struct {
-	conns     chan *dns.Conn
-	addresses []string
-	capacity  int
+	conns    chan *dns.Conn
+	address  string
+	timeout  time.Duration
+	capacity int
+	closed   atomic.Bool
 }
 
-func NewUDPPool(size int, addrs []string) *UDPPool {
+func NewUDPPool(size int, addr string, timeout time.Duration) *UDPPool {
 	return &UDPPool{
-		conns:     make(chan *dns.Conn, size),
-		addresses: addrs,
-		capacity:  size,
+		conns:    make(chan *dns.Conn, size),
+		address:  addr,
+		timeout:  timeout,
+		capacity: size,
 	}
 }
 
 func (p *UDPPool) NewConn() (*dns.Conn, error) {
-	var lastErr error
-
-	for _, addr := range p.addresses {
-		conn, err := p.Dial(addr)
-		if err == nil {
-			return conn, nil
-		}
-
-		lastErr = err
-	}
-
-	return nil, fmt.Errorf("Error Failed to Dial DNS Upstreams: %v", lastErr)
-}
-
-func (p *UDPPool) Dial(addr string) (*dns.Conn, error) {
 	c := new(dns.Client)
 	c.Net = "udp"
 
 	c.Dialer = &net.Dialer{
-		Timeout:   time.Duration(config.Upstream.Timeout) * time.Second,
+		Timeout:   p.timeout,
 		KeepAlive: time.Duration(config.Upstream.KeepAlive) * time.Second,
 		Control:   setSocketOptions,
 	}
 
-	conn, err := c.Dial(addr)
+	conn, err := c.Dial(p.address)
 	if err == nil {
 		if tcpConn, ok := conn.Conn.(*net.TCPConn); ok {
 			setTCPOptions(tcpConn)
@@ -85,9 +73,38 @@ func (p *UDPPool) Return(c *dns.Conn) {
 		return
 	}
 
+	if p.closed.Load() {
+		c.Close()
+		return
+	}
+
 	select {
 	case p.conns <- c:
 	default:
 		c.Close()
 	}
+
+	// Pool Closed While Returning, Drain Connection Just Pooled
+	if p.closed.Load() {
+		p.drain()
+	}
+}
+
+// Close Pooled Connections, Connections Returned Later are Closed Too
+func (p *UDPPool) Close() {
+	p.closed.Store(true)
+	p.drain()
+}
+
+func (p *UDPPool) drain() {
+	for {
+		select {
+		case c := <-p.conns:
+			if c != nil {
+				c.Close()
+			}
+		default:
+			return
+		}
+	}
 }
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type Upstream struct {
+	Proto         string
+	Address       string
+	Host          string
+	URL           string
+	SkipTLSVerify bool
+	Timeout       time.Duration
+	Weight        int
+
+	latency        time.Duration
+	unhealthy      bool
+	probeFailures  int
+	probeSuccesses int
+	breaker        *CircuitBreaker
+	mu             sync.Mutex
+
+	udpPool   *UDPPool
+	tcpPool   *TCPPool
+	quicPool  *QUICPool
+	dohClient *http.Client
+}
+
+const upstreamEWMAWeight = 0.3
+
+var upstreamSchemes = map[string]string{
+	"udp":   "udp",
+	"tcp":   "tcp",
+	"tls":   "dot",
+	"https": "doh",
+	"quic":  "doq",
+}
+
+var upstreamPorts = map[string]string{
+	"udp": "53",
+	"tcp": "53",
+	"dot": "853",
+	"doh": "443",
+	"doq": "853",
+}
+
+func NewUpstream(raw string, cfg UpstreamConfig) (*Upstream, error) {
+	u, err := parseUpstream(raw, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	u.breaker = NewCircuitBreaker(cfg.CircuitBreaker, u.String())
+
+	switch u.Proto {
+	case "udp":
+		u.udpPool = NewUDPPool(cfg.PoolSize, u.Address, u.Timeout)
+		u.tcpPool = NewTCPPool(cfg.PoolSize, u.Address, u.Host, "tcp", u.SkipTLSVerify, u.Timeout)
+	case "tcp", "dot":
+		u.tcpPool = NewTCPPool(cfg.PoolSize, u.Address, u.Host, u.Proto, u.SkipTLSVerify, u.Timeout)
+	case "doq":
+		u.quicPool = NewQUICPool(cfg.PoolSize, u.Address, u.Host, u.SkipTLSVerify, u.Timeout, time.Duration(cfg.KeepAlive)*time.Second)
+	case "doh":
+		u.dohClient = newDoHClient(cfg, u.Host, u.SkipTLSVerify, u.Address, u.Timeout)
+	}
+
+	return u, nil
+}
+
+func parseUpstream(raw string, cfg UpstreamConfig) (*Upstream, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, errors.New("Error Empty DNS Upstream Address")
+	}
+
+	u := &Upstream{
+		Proto:         cfg.Mode,
+		SkipTLSVerify: cfg.SkipTLSVerify,
+		Timeout:       time.Duration(cfg.Timeout) * time.Second,
+		Weight:        1,
+	}
+
+	// Per-Entry Options are Passed as Query Parameters
+	var query url.Values
+	var err error
+
+	// Bare Host:Port Entry Use Default Mode and Domain
+	if !strings.Contains(raw, "://") {
+		port, found := upstreamPorts[u.Proto]
+		if !found {
+			return nil, fmt.Errorf("Error Invalid DNS Upstream Mode '%s'", u.Proto)
+		}
+
+		host, rawQuery, _ := strings.Cut(raw, "?")
+
+		query, err = url.ParseQuery(rawQuery)
+		if err != nil {
+			return nil, fmt.Errorf("Error Invalid DNS Upstream '%s': %w", raw, err)
+		}
+
+		u.Address = withDefaultPort(host, port)
+
+		u.Host = cfg.Domain
+		if u.Host == "" {
+			u.Host = hostOnly(u.Address)
+		}
+
+		if u.Proto == "doh" {
+			u.URL = "https://" + host + cfg.DoH.QueryPath
+		}
+
+		return u, applyUpstreamOptions(u, raw, query)
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Error Invalid DNS Upstream '%s': %w", raw, err)
+	}
+
+	proto, found := upstreamSchemes[strings.ToLower(parsed.Scheme)]
+	if !found {
+		return nil, fmt.Errorf("Error Unsupported DNS Upstream Scheme '%s'", parsed.Scheme)
+	}
+
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("Error Invalid DNS Upstream '%s': Missing Host", raw)
+	}
+
+	u.Proto = proto
+	u.Address = withDefaultPort(parsed.Host, upstreamPorts[proto])
+
+	// Default Domain Only Applies to Bare Entries, URL Entries
+	// Use Their Own Host Unless Server Name is Pinned
+	u.Host = parsed.Hostname()
+
+	// Server Name Can be Pinned Using SNI@Address Format
+	if parsed.User != nil && parsed.User.Username() != "" {
+		u.Host = parsed.User.Username()
+	}
+
+	query = parsed.Query()
+	if err := applyUpstreamOptions(u, raw, query); err != nil {
+		return nil, err
+	}
+
+	if proto == "doh" {
+		queryURL := &url.URL{
+			Scheme:   "https",
+			Host:     parsed.Host,
+			Path:     parsed.Path,
+			RawQuery: query.Encode(),
+		}
+
+		// Request Host Follow Pinned Server Name
+		if parsed.User != nil && parsed.User.Username() != "" {
+			queryURL.Host = u.Host
+			if port := parsed.Port(); port != "" && port != upstreamPorts[proto] {
+				queryURL.Host = net.JoinHostPort(u.Host, port)
+			}
+		}
+
+		if queryURL.Path == "" {
+			queryURL.Path = cfg.DoH.QueryPath
+		}
+
+		u.URL = queryURL.String()
+	}
+
+	return u, nil
+}
+
+// Apply Known Options and Remove Them from Query,
+// So the Rest Can be Passed Through to DoH URL
+func applyUpstreamOptions(u *Upstream, raw string, query url.Values) error {
+	var err error
+
+	if sni := query.Get("sni"); sni != "" {
+		u.Host = sni
+	}
+
+	if skipVerify := query.Get("skip_tls_verify"); skipVerify != "" {
+		u.SkipTLSVerify, err = strconv.ParseBool(skipVerify)
+		if err != nil {
+			return fmt.Errorf("Error Invalid DNS Upstream '%s': Invalid skip_tls_verify Value", raw)
+		}
+	}
+
+	if timeout := query.Get("timeout"); timeout != "" {
+		seconds, err := strconv.Atoi(timeout)
+		if err != nil || seconds < 1 {
+			return fmt.Errorf("Error Invalid DNS Upstream '%s': Invalid timeout Value", raw)
+		}
+
+		u.Timeout = time.Duration(seconds) * time.Second
+	}
+
+	if weight := query.Get("weight"); weight != "" {
+		u.Weight, err = strconv.Atoi(weight)
+		if err != nil || u.Weight < 1 {
+			return fmt.Errorf("Error Invalid DNS Upstream '%s': Invalid weight Value", raw)
+		}
+	}
+
+	query.Del("sni")
+	query.Del("skip_tls_verify")
+	query.Del("timeout")
+	query.Del("weight")
+
+	return nil
+}
+
+// Close Pooled Connections of Replaced Upstream, Called After
+// Reload Once No Query Can Use It Anymore
+func (u *Upstream) Close() {
+	if u.udpPool != nil {
+		u.udpPool.Close()
+	}
+
+	if u.tcpPool != nil {
+		u.tcpPool.Close()
+	}
+
+	if u.quicPool != nil {
+		u.quicPool.Close()
+	}
+
+	if u.dohClient != nil {
+		u.dohClient.CloseIdleConnections()
+	}
+}
+
+func (u *Upstream) String() string {
+	switch u.Proto {
+	case "doh":
+		return u.URL
+	case "dot":
+		return "tls://" + u.Host + "@" + u.Address
+	case "doq":
+		return "quic://" + u.Host + "@" + u.Address
+	}
+
+	return u.Proto + "://" + u.Address
+}
+
+func (u *Upstream) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	switch u.Proto {
+	case "tcp", "dot":
+		return forwardTCP(ctx, m, u)
+	case "doh":
+		return forwardDoH(ctx, m, u)
+	case "doq":
+		return forwardDoQ(ctx, m, u)
+	}
+
+	return forwardUDP(ctx, m, u)
+}
+
+func forwardUpstreams(m *dns.Msg, group *UpstreamGroup) (*dns.Msg, error) {
+	var lastErr error
+
+	upstreams := group.Order()
+	if len(upstreams) == 0 {
+		return nil, errors.New("Error No DNS Upstreams Available")
+	}
+
+	attempts := 0
+	maxAttempts := config.Upstream.MaxAttempts
+
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	// Number of Upstreams Queried at The Same Time per Attempt
+	batchSize := group.parallel
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if batchSize > len(upstreams) {
+		batchSize = len(upstreams)
+	}
+
+	for attempts < maxAttempts {
+		var resp *dns.Msg
+		var err error
+
+		if batchSize == 1 {
+			resp, err = exchangeUpstream(context.Background(), m, upstreams[attempts%len(upstreams)])
+		} else {
+			batch := make([]*Upstream, batchSize)
+			for i := range batch {
+				batch[i] = upstreams[(attempts*batchSize+i)%len(upstreams)]
+			}
+
+			resp, err = raceUpstreams(m, batch)
+		}
+
+		if err == nil {
+			return resp, nil
+		}
+
+		lastErr = err
+		attempts++
+	}
+
+	return nil, fmt.Errorf("Error DNS Upstream Failed After %d Attempts: %v", attempts, lastErr)
+}
+
+func exchangeUpstream(ctx context.Context, m *dns.Msg, u *Upstream) (*dns.Msg, error) {
+	if !u.breaker.Acquire() {
+		return nil, fmt.Errorf("Error DNS Upstream %s Circuit is Open", u)
+	}
+
+	start := time.Now()
+
+	resp, err := u.Exchange(ctx, m)
+	if err != nil {
+		// Penalize Failed Upstream with Its Full Timeout,
+		// Except when It Was Cancelled After Losing a Race
+		if ctx.Err() == nil {
+			u.observeLatency(u.Timeout)
+			u.breaker.Failure(err)
+		} else {
+			u.breaker.Release()
+		}
+
+		return nil, err
+	}
+
+	u.observeLatency(time.Since(start))
+
+	if resp.Rcode == dns.RcodeServerFailure {
+		u.breaker.Failure(errors.New("Error DNS Upstream Returned SERVFAIL"))
+	} else {
+		u.breaker.Success()
+	}
+
+	return resp, nil
+}
+
+// Exponentially Weighted Moving Average of Upstream Latency
+func (u *Upstream) observeLatency(sample time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.latency == 0 {
+		u.latency = sample
+		return
+	}
+
+	u.latency = time.Duration(upstreamEWMAWeight*float64(sample) + (1-upstreamEWMAWeight)*float64(u.latency))
+}
+
+func (u *Upstream) Latency() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.latency
+}
+
+func withDefaultPort(host string, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+)
+
+type UpstreamGroup struct {
+	upstreams []*Upstream
+	strategy  string
+	parallel  int
+	next      atomic.Uint64
+}
+
+func NewUpstreamGroup(upstreams []*Upstream, strategy string, parallel int) (*UpstreamGroup, error) {
+	switch strategy {
+	case "":
+		strategy = "failover"
+	case "failover", "round_robin", "random", "weighted", "fastest":
+	default:
+		return nil, fmt.Errorf("Error Invalid DNS Upstream Strategy '%s'", strategy)
+	}
+
+	return &UpstreamGroup{
+		upstreams: upstreams,
+		strategy:  strategy,
+		parallel:  parallel,
+	}, nil
+}
+
+// Return Upstreams in The Order They Should be Tried
+// Based on Configured Strategy
+func (g *UpstreamGroup) Order() []*Upstream {
+	candidates := g.available()
+	if len(candidates) < 2 {
+		return candidates
+	}
+
+	order := make([]*Upstream, len(candidates))
+
+	switch g.strategy {
+	case "round_robin":
+		start := int(g.next.Add(1)-1) % len(candidates)
+		for i := range candidates {
+			order[i] = candidates[(start+i)%len(candidates)]
+		}
+
+	case "random":
+		for i, j := range rand.Perm(len(candidates)) {
+			order[i] = candidates[j]
+		}
+
+	case "weighted":
+		// Weighted Random Shuffle (Efraimidis-Spirakis)
+		keys := make(map[*Upstream]float64, len(candidates))
+		for _, u := range candidates {
+			keys[u] = math.Pow(rand.Float64(), 1/float64(u.Weight))
+		}
+
+		copy(order, candidates)
+		sort.SliceStable(order, func(i, j int) bool {
+			return keys[order[i]] > keys[order[j]]
+		})
+
+	case "fastest":
+		// Upstreams Without Samples Go First to be Measured
+		latencies := make(map[*Upstream]int64, len(candidates))
+		for _, u := range candidates {
+			latencies[u] = int64(u.Latency())
+		}
+
+		copy(order, candidates)
+		sort.SliceStable(order, func(i, j int) bool {
+			return latencies[order[i]] < latencies[order[j]]
+		})
+
+	default:
+		copy(order, candidates)
+	}
+
+	return order
+}
+
+// Skip Unhealthy and Open Circuit Upstreams, But Fallback to
+// All of Them When None is Available Rather than Failing Every Query.
+// Open Circuits Then Let One Trial Query Through Before Backoff Ends
+func (g *UpstreamGroup) available() []*Upstream {
+	healthy := make([]*Upstream, 0, len(g.upstreams))
+	for _, u := range g.upstreams {
+		if u.Healthy() && u.breaker.Ready() {
+			healthy = append(healthy, u)
+		}
+	}
+
+	if len(healthy) == 0 {
+		for _, u := range g.upstreams {
+			u.breaker.ForceTrial()
+		}
+
+		return g.upstreams
+	}
+
+	return healthy
+}
+
+func (g *UpstreamGroup) Upstreams() []*Upstream {
+	return g.upstreams
+}
+
+func (g *UpstreamGroup) Close() {
+	for _, u := range g.upstreams {
+		u.Close()
+	}
+}
+
+func (g *UpstreamGroup) String() string {
+	if g.parallel > 1 {
+		return fmt.Sprintf("%v (%s, Parallel: %d)", g.upstreams, g.strategy, g.parallel)
+	}
+
+	return fmt.Sprintf("%v (%s)", g.upstreams, g.strategy)
+}
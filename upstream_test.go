@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUpstream(t *testing.T) {
+	cfg := UpstreamConfig{
+		Mode:    "udp",
+		Timeout: 5,
+		DoH: DoHConfig{
+			QueryPath: "/dns-query",
+		},
+	}
+
+	tests := []struct {
+		raw  string
+		mode string
+		want *Upstream
+	}{
+		{
+			raw:  "1.1.1.1",
+			want: &Upstream{Proto: "udp", Address: "1.1.1.1:53", Host: "1.1.1.1"},
+		},
+		{
+			raw:  "[2606:4700::1111]:5353",
+			want: &Upstream{Proto: "udp", Address: "[2606:4700::1111]:5353", Host: "2606:4700::1111"},
+		},
+		{
+			raw:  "1.1.1.1",
+			mode: "dot",
+			want: &Upstream{Proto: "dot", Address: "1.1.1.1:853", Host: "one.one.one.one"},
+		},
+		{
+			raw:  "1.1.1.1?weight=3&timeout=2",
+			mode: "doh",
+			want: &Upstream{Proto: "doh", Address: "1.1.1.1:443", Host: "one.one.one.one", URL: "https://1.1.1.1/dns-query", Weight: 3, Timeout: 2 * time.Second},
+		},
+		{
+			raw:  "tcp://9.9.9.9",
+			want: &Upstream{Proto: "tcp", Address: "9.9.9.9:53", Host: "9.9.9.9"},
+		},
+		{
+			raw:  "tls://1.1.1.1",
+			mode: "dot",
+			want: &Upstream{Proto: "dot", Address: "1.1.1.1:853", Host: "1.1.1.1"},
+		},
+		{
+			raw:  "tls://dns.google@8.8.8.8",
+			want: &Upstream{Proto: "dot", Address: "8.8.8.8:853", Host: "dns.google"},
+		},
+		{
+			raw:  "quic://dns.adguard.com:8853?sni=dns.example.net",
+			want: &Upstream{Proto: "doq", Address: "dns.adguard.com:8853", Host: "dns.example.net"},
+		},
+		{
+			raw:  "https://dns.google",
+			want: &Upstream{Proto: "doh", Address: "dns.google:443", Host: "dns.google", URL: "https://dns.google/dns-query"},
+		},
+		{
+			raw:  "https://dns.google@8.8.8.8:8443/resolve?ct=1&skip_tls_verify=true",
+			want: &Upstream{Proto: "doh", Address: "8.8.8.8:8443", Host: "dns.google", URL: "https://dns.google:8443/resolve?ct=1", SkipTLSVerify: true},
+		},
+		{
+			raw:  "https://dns.google@8.8.8.8/dns-query",
+			want: &Upstream{Proto: "doh", Address: "8.8.8.8:443", Host: "dns.google", URL: "https://dns.google/dns-query"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			entryCfg := cfg
+			if tt.mode != "" {
+				entryCfg.Mode = tt.mode
+				entryCfg.Domain = "one.one.one.one"
+			}
+
+			u, err := parseUpstream(tt.raw, entryCfg)
+			if err != nil {
+				t.Fatalf("parseUpstream(%q): %v", tt.raw, err)
+			}
+
+			want := tt.want
+			if want.Weight == 0 {
+				want.Weight = 1
+			}
+
+			if want.Timeout == 0 {
+				want.Timeout = time.Duration(cfg.Timeout) * time.Second
+			}
+
+			if u.Proto != want.Proto || u.Address != want.Address || u.Host != want.Host || u.URL != want.URL {
+				t.Errorf("parseUpstream(%q) = %s %s %s %s, want %s %s %s %s", tt.raw, u.Proto, u.Address, u.Host, u.URL, want.Proto, want.Address, want.Host, want.URL)
+			}
+
+			if u.SkipTLSVerify != want.SkipTLSVerify || u.Timeout != want.Timeout || u.Weight != want.Weight {
+				t.Errorf("parseUpstream(%q) options = %v %v %d, want %v %v %d", tt.raw, u.SkipTLSVerify, u.Timeout, u.Weight, want.SkipTLSVerify, want.Timeout, want.Weight)
+			}
+		})
+	}
+}
+
+func TestParseUpstreamErrors(t *testing.T) {
+	cfg := UpstreamConfig{Mode: "udp", Timeout: 5}
+
+	tests := []struct {
+		raw  string
+		mode string
+	}{
+		{raw: ""},
+		{raw: "1.1.1.1", mode: "bogus"},
+		{raw: "ftp://1.1.1.1"},
+		{raw: "tls://"},
+		{raw: "1.1.1.1?weight=0"},
+		{raw: "1.1.1.1?timeout=abc"},
+		{raw: "tls://1.1.1.1?skip_tls_verify=maybe"},
+	}
+
+	for _, tt := range tests {
+		entryCfg := cfg
+		if tt.mode != "" {
+			entryCfg.Mode = tt.mode
+		}
+
+		if u, err := parseUpstream(tt.raw, entryCfg); err == nil {
+			t.Errorf("parseUpstream(%q) = %v, want error", tt.raw, u)
+		}
+	}
+}
@@ -38,6 +38,14 @@ func isNetworkError(err error) bool {
 	return false
 }
 
+func isTimeoutError(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+
+	return false
+}
+
 func parseIncludeFiles(baseDir string, patterns []string) []string {
 	var files []string
 
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Response Writer for Non dns.Server Listeners
+// It Captures the Reply Written by handleRequest
+type msgResponseWriter struct {
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	path       string
+	msg        *dns.Msg
+}
+
+func (w *msgResponseWriter) LocalAddr() net.Addr {
+	return w.localAddr
+}
+
+func (w *msgResponseWriter) RemoteAddr() net.Addr {
+	return w.remoteAddr
+}
+
+func (w *msgResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *msgResponseWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+
+	w.msg = m
+	return len(b), nil
+}
+
+func (w *msgResponseWriter) Close() error {
+	return nil
+}
+
+func (w *msgResponseWriter) TsigStatus() error {
+	return nil
+}
+
+func (w *msgResponseWriter) TsigTimersOnly(bool) {}
+
+func (w *msgResponseWriter) Hijack() {}
+
+// Write Reply to Client and Truncate it when it Exceeds
+// The Buffer Size Advertised by UDP Client
+func writeResponse(w dns.ResponseWriter, r *dns.Msg, resp *dns.Msg) error {
+	// SetReply Resets Rcode, Keep The One From Resolver
+	rcode := resp.Rcode
+
+	resp.SetReply(r)
+	resp.Rcode = rcode
+	resp.Compress = config.Server.Compress
+
+	// Reply Carries OPT Only if Request Had One (RFC 6891) and Client
+	// Subnet Only if Request Had It (RFC 7871), Upstream or Bogus NXDOMAIN
+	// Replies May Have Both Due to Client Subnet Added by Us
+	reqOpt, reqECS := r.IsEdns0(), findECS(r)
+
+	extra := resp.Extra[:0:0]
+	for _, rr := range resp.Extra {
+		opt, ok := rr.(*dns.OPT)
+		if !ok {
+			extra = append(extra, rr)
+			continue
+		}
+
+		if reqOpt == nil {
+			continue
+		}
+
+		if reqECS == nil {
+			opt = withoutECS(opt)
+		}
+
+		extra = append(extra, opt)
+	}
+
+	resp.Extra = extra
+
+	if isUDPClient(w) {
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+			size = int(opt.UDPSize())
+		}
+
+		resp.Truncate(size)
+	}
+
+	return w.WriteMsg(resp)
+}
+
+// DoH and DoQ Clients Use Stream Based Transport
+// Even Though DoQ Remote Address is UDP
+func isUDPClient(w dns.ResponseWriter) bool {
+	if _, ok := w.(*msgResponseWriter); ok {
+		return false
+	}
+
+	_, ok := w.RemoteAddr().(*net.UDPAddr)
+	return ok
+}